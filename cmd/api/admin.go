@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// adminUser exposes the fields data.User hides from regular API consumers.
+type adminUser struct {
+	*data.User
+	Email       string           `json:"email"`
+	Roles       []string         `json:"roles,omitzero"`
+	Permissions data.Permissions `json:"permissions,omitzero"`
+}
+
+func (app *application) readAdminUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search    string
+		Activated string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "q", "")
+	input.Activated = app.readString(qs, "activated", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "username", "created_at", "-id", "-username", "-created_at"}
+
+	v.Check(validator.PermittedValue(input.Activated, "", "true", "false"), "activated", "must be true or false")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Search, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	result := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		result = append(result, adminUser{User: user, Email: user.Email})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": result, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeAdminUser(w, r, user)
+}
+
+// writeAdminUser responds with the user together with their roles and effective permissions.
+func (app *application) writeAdminUser(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": adminUser{User: user, Email: user.Email, Roles: roles, Permissions: permissions}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "must be provided")
+	if input.Activated != nil && !*input.Activated {
+		v.Check(user.ID != app.contextGetUser(r).ID, "activated", "you cannot deactivate your own account")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		err = app.models.Tokens.DeleteAllSessionsForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s set activated=%t for user %s", app.contextGetUser(r).Username, user.Activated, user.Username))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": adminUser{User: user, Email: user.Email}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least one permission")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	for _, code := range input.Codes {
+		v.Check(known.Include(code), "codes", fmt.Sprintf("unknown permission %q", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s granted %v to user %s", app.contextGetUser(r).Username, input.Codes, user.Username))
+
+	app.writeAdminUser(w, r, user)
+}
+
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	if user.ID == app.contextGetUser(r).ID && code == "users:manage" {
+		app.badRequestResponse(w, r, errors.New("you cannot revoke users:manage from yourself"))
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(r.Context(), user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s revoked %s from user %s", app.contextGetUser(r).Username, code, user.Username))
+
+	app.writeAdminUser(w, r, user)
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) assignRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	known := make([]string, 0, len(roles))
+	for _, role := range roles {
+		known = append(known, role.Code)
+	}
+
+	v := validator.New()
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least one role")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	for _, code := range input.Codes {
+		v.Check(validator.PermittedValue(code, known...), "codes", fmt.Sprintf("unknown role %q", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.AddForUser(r.Context(), user.ID, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s assigned roles %v to user %s", app.contextGetUser(r).Username, input.Codes, user.Username))
+
+	app.writeAdminUser(w, r, user)
+}
+
+func (app *application) unassignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	if user.ID == app.contextGetUser(r).ID && code == "admin" {
+		app.badRequestResponse(w, r, errors.New("you cannot remove the admin role from yourself"))
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(r.Context(), user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s removed role %s from user %s", app.contextGetUser(r).Username, code, user.Username))
+
+	app.writeAdminUser(w, r, user)
+}
+
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s logged out user %s", app.contextGetUser(r).Username, user.Username))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": fmt.Sprintf("all sessions of %s have been revoked", user.Username)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("use DELETE /v1/users/me to delete your own account"))
+		return
+	}
+
+	err := app.models.Users.Delete(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s deleted user %s", app.contextGetUser(r).Username, user.Username))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// config holds every setting of the API. Settings are layered, in increasing precedence: the defaults
+// below, the TOML file given with -config, UKROP_* environment variables and command-line flags. Every
+// setting is named after its flag, and UKROP_DB_DSN or
+//
+//	[db]
+//	dsn = "postgres://..."
+//
+// in the file both set -db-dsn.
+type config struct {
+	port   int
+	env    string
+	socket struct {
+		path string
+		mode fileMode
+	}
+	server struct {
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		maxHeaderBytes    int
+		drainDelay        time.Duration
+		shutdownTimeout   time.Duration
+		backgroundTimeout time.Duration
+	}
+	db struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	yt struct {
+		apiKey     string
+		maxResults int
+	}
+	sp struct {
+		clientID     string
+		clientSecret string
+		maxResults   int
+	}
+	cors struct {
+		trustedOrigins stringList
+	}
+	users struct {
+		defaultRole string
+	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	jwt   jwtConfig
+	login struct {
+		maxAttempts int
+		lockout     time.Duration
+		maxLockout  time.Duration
+	}
+	maintenance struct {
+		interval         time.Duration
+		unactivatedGrace time.Duration
+	}
+	healthcheck struct {
+		timeout  time.Duration
+		cacheTTL time.Duration
+		probe    bool
+	}
+	log struct {
+		format string
+		level  string
+	}
+	otel struct {
+		exporter    string
+		endpoint    string
+		file        string
+		sampleRatio float64
+	}
+	tls struct {
+		certFile     string
+		keyFile      string
+		acmeDomains  stringList
+		acmeEmail    string
+		acmeCacheDir string
+		redirectPort int
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+
+	// only set on the command line
+	file           string
+	displayVersion bool
+	printConfig    bool
+
+	flags *flag.FlagSet // bound to this config, used to print it
+}
+
+// jwtConfig enables stateless signed access tokens when a secret is set.
+type jwtConfig struct {
+	secret     string
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func (c jwtConfig) enabled() bool {
+	return c.secret != ""
+}
+
+// cliOnlySettings can't be set from the file or the environment and aren't printed.
+var cliOnlySettings = map[string]bool{
+	"config":        true,
+	"version":       true,
+	"print-config":  true,
+	"yt-max-resuts": true, // deprecated aliases
+	"sp-max-resuts": true,
+}
+
+// legacyEnv maps the environment variables read before UKROP_* naming to their settings. The UKROP_*
+// variable wins when both are set.
+var legacyEnv = map[string]string{
+	"YOUTUBE_API_KEY":       "yt-api-key",
+	"SPOTIFY_CLIENT_ID":     "sp-client-id",
+	"SPOTIFY_CLIENT_SECRET": "sp-client-secret",
+}
+
+// secretSettings are redacted when the configuration is printed.
+var secretSettings = map[string]bool{
+	"smtp-password":      true,
+	"yt-api-key":         true,
+	"sp-client-secret":   true,
+	"jwt-secret":         true,
+	"oidc-client-secret": true,
+}
+
+// loadConfig layers the configuration sources and validates the result. lookupEnv is os.LookupEnv
+// outside of tests.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (*config, error) {
+	cfg := &config{}
+	fs := cfg.flagSet()
+
+	// the first pass only finds the config file, the command line gets applied again once the file
+	// and the environment have been
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// printing the version must work without a usable configuration
+	if cfg.displayVersion {
+		return cfg, nil
+	}
+
+	if cfg.file == "" {
+		cfg.file, _ = lookupEnv("UKROP_CONFIG")
+	}
+
+	if cfg.file != "" {
+		settings, err := readConfigFile(cfg.file)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range settings {
+			if fs.Lookup(name) == nil || cliOnlySettings[name] {
+				return nil, fmt.Errorf("%s: unknown setting %q", cfg.file, name)
+			}
+
+			err = fs.Set(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", cfg.file, name, err)
+			}
+		}
+	}
+
+	env := make(map[string]string)
+	for legacy, name := range legacyEnv {
+		if value, ok := lookupEnv(legacy); ok && value != "" {
+			env[name] = value
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if cliOnlySettings[f.Name] {
+			return
+		}
+
+		key := envKey(f.Name)
+		if value, ok := lookupEnv(key); ok && value != "" {
+			env[f.Name] = value
+		}
+
+		if value, ok := env[f.Name]; ok {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.flags = fs
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	cfg.socket.mode = 0o660
+	fs.StringVar(&cfg.socket.path, "socket", "", "Unix socket to listen on instead of the port (ignored under systemd socket activation)")
+	fs.Var(&cfg.socket.mode, "socket-mode", "File permissions of the Unix socket")
+
+	fs.DurationVar(&cfg.server.readTimeout, "server-read-timeout", 5*time.Second, "Maximum duration of reading a whole request")
+	fs.DurationVar(&cfg.server.readHeaderTimeout, "server-read-header-timeout", 2*time.Second, "Maximum duration of reading request headers")
+	fs.DurationVar(&cfg.server.writeTimeout, "server-write-timeout", 10*time.Second, "Maximum duration of writing a response")
+	fs.DurationVar(&cfg.server.idleTimeout, "server-idle-timeout", time.Minute, "How long idle keep-alive connections are kept open")
+	fs.IntVar(&cfg.server.maxHeaderBytes, "server-max-header-bytes", 64<<10, "Maximum size of request headers")
+	fs.DurationVar(&cfg.server.drainDelay, "server-drain-delay", 0, "How long readiness checks fail before shutdown stops accepting connections")
+	fs.DurationVar(&cfg.server.shutdownTimeout, "server-shutdown-timeout", 30*time.Second, "How long shutdown waits for in-flight requests")
+	fs.DurationVar(&cfg.server.backgroundTimeout, "server-background-timeout", 30*time.Second, "How long shutdown waits for background tasks like sending emails")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN (empty leaves the connection settings to the PG* environment variables)")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 20, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Ukrop <no-reply@ukrop.pl>", "SMTP sender")
+
+	fs.StringVar(&cfg.yt.apiKey, "yt-api-key", "", "Api key for Youtube Data")
+	fs.IntVar(&cfg.yt.maxResults, "yt-max-results", 5, "Max queries returned by Youtube api at once")
+	fs.IntVar(&cfg.yt.maxResults, "yt-max-resuts", 5, "Deprecated alias of -yt-max-results")
+
+	fs.StringVar(&cfg.sp.clientID, "sp-client-id", "", "Client ID for Spotify")
+	fs.StringVar(&cfg.sp.clientSecret, "sp-client-secret", "", "Client Secret for Spotify")
+	fs.IntVar(&cfg.sp.maxResults, "sp-max-results", 5, "Max queries returned by Spotify api at once")
+	fs.IntVar(&cfg.sp.maxResults, "sp-max-resuts", 5, "Deprecated alias of -sp-max-results")
+
+	fs.Var(&cfg.cors.trustedOrigins, "cors-trusted-origins", "Trusted CORS origins (space separate)")
+
+	fs.StringVar(&cfg.users.defaultRole, "users-default-role", "member", "Role assigned to newly registered users (empty to assign none)")
+
+	fs.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", 0, "How long user permissions are cached in memory (0 disables the cache)")
+
+	fs.StringVar(&cfg.jwt.secret, "jwt-secret", "", "HMAC secret for signed access tokens (empty keeps opaque tokens only)")
+	fs.StringVar(&cfg.jwt.issuer, "jwt-issuer", "api.ukrop.pl", "Issuer of signed access tokens")
+	fs.DurationVar(&cfg.jwt.accessTTL, "jwt-access-ttl", 15*time.Minute, "Lifetime of signed access tokens")
+	fs.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	fs.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins per account or IP before logins get locked out")
+	fs.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First login lockout, doubled with every further failure")
+	fs.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Maximum login lockout")
+
+	fs.DurationVar(&cfg.maintenance.interval, "maintenance-interval", time.Hour, "How often expired tokens and stale accounts are purged")
+	fs.DurationVar(&cfg.maintenance.unactivatedGrace, "users-unactivated-grace", 0, "Delete accounts never activated within this long after registering, e.g. 168h (0 keeps them)")
+
+	fs.DurationVar(&cfg.healthcheck.timeout, "healthcheck-timeout", 2*time.Second, "Timeout of each dependency check in the readiness check")
+	fs.DurationVar(&cfg.healthcheck.cacheTTL, "healthcheck-cache-ttl", 5*time.Second, "How long readiness check results are reused")
+	fs.BoolVar(&cfg.healthcheck.probe, "healthcheck-probe", false, "Call the YouTube and Spotify APIs in the readiness check instead of only checking their credentials are set")
+
+	fs.StringVar(&cfg.log.format, "log-format", "text", "Log format (text|json)")
+	fs.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+
+	fs.StringVar(&cfg.otel.exporter, "otel-exporter", "", "OpenTelemetry trace exporter: otlp, stdout or file (empty disables tracing)")
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OTLP/HTTP endpoint URL (empty uses OTEL_EXPORTER_OTLP_* or localhost:4318)")
+	fs.StringVar(&cfg.otel.file, "otel-file", "traces.jsonl", "File spans are appended to by the file exporter")
+	fs.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces to sample (0-1)")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "TLS certificate file, reloaded when it changes (empty serves plain HTTP unless ACME is used)")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "TLS private key file")
+	fs.Var(&cfg.tls.acmeDomains, "tls-acme-domains", "Domains to obtain certificates for through ACME (space separated), instead of certificate files")
+	fs.StringVar(&cfg.tls.acmeEmail, "tls-acme-email", "", "Contact email of the ACME account")
+	fs.StringVar(&cfg.tls.acmeCacheDir, "tls-acme-cache-dir", "certs", "Directory ACME certificates are cached in")
+	fs.IntVar(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS, needed for ACME HTTP challenges (0 disables it)")
+
+	fs.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL (empty disables signing in through a provider)")
+	fs.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	fs.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "URL the provider sends users back to with the authorization code")
+
+	fs.StringVar(&cfg.file, "config", "", "TOML config file (defaults to $UKROP_CONFIG)")
+	fs.BoolVar(&cfg.displayVersion, "version", false, "Display version and exit")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+
+	return fs
+}
+
+// validate checks the settings that can be checked without connecting anywhere, and reports every
+// problem at once.
+func (cfg *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.port > 0 && cfg.port <= 65535, "port must be between 1 and 65535")
+	check(cfg.env == "development" || cfg.env == "staging" || cfg.env == "production", "env must be development, staging or production")
+
+	check(cfg.server.readTimeout > 0 && cfg.server.readHeaderTimeout > 0 && cfg.server.writeTimeout > 0 && cfg.server.idleTimeout > 0,
+		"server-read-timeout, server-read-header-timeout, server-write-timeout and server-idle-timeout must be positive")
+	check(cfg.server.maxHeaderBytes >= 4<<10, "server-max-header-bytes must be at least 4096")
+	check(cfg.server.drainDelay >= 0, "server-drain-delay must not be negative")
+	check(cfg.server.shutdownTimeout > 0 && cfg.server.backgroundTimeout > 0, "server-shutdown-timeout and server-background-timeout must be positive")
+
+	check(cfg.db.maxOpenConns >= 0 && cfg.db.maxIdleConns >= 0, "db-max-open-conns and db-max-idle-conns must not be negative")
+
+	check(!cfg.limiter.enabled || cfg.limiter.rps > 0 && cfg.limiter.burst > 0, "limiter-rps and limiter-burst must be positive when the limiter is enabled")
+
+	check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port must be between 1 and 65535")
+
+	check(cfg.yt.maxResults > 0 && cfg.yt.maxResults <= 50, "yt-max-results must be between 1 and 50")
+	check(cfg.sp.maxResults > 0 && cfg.sp.maxResults <= 50, "sp-max-results must be between 1 and 50")
+
+	check(!cfg.jwt.enabled() || len(cfg.jwt.secret) >= 32, "jwt-secret must be at least 32 bytes long")
+
+	check(cfg.login.maxAttempts >= 1, "login-max-attempts must be at least 1")
+	check(cfg.login.lockout > 0 && cfg.login.maxLockout >= cfg.login.lockout, "login-lockout must be positive and no longer than login-max-lockout")
+
+	check(cfg.maintenance.interval > 0, "maintenance-interval must be positive")
+
+	check(cfg.healthcheck.timeout > 0, "healthcheck-timeout must be positive")
+
+	check(cfg.log.format == "text" || cfg.log.format == "json", "log-format must be text or json")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.log.level)) == nil, "log-level must be debug, info, warn or error")
+
+	check(cfg.otel.sampleRatio >= 0 && cfg.otel.sampleRatio <= 1, "otel-sample-ratio must be between 0 and 1")
+
+	check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-cert-file and tls-key-file must be set together")
+	check(cfg.tls.certFile == "" || len(cfg.tls.acmeDomains) == 0, "tls-cert-file and tls-acme-domains can't be used together")
+	check(cfg.tls.redirectPort >= 0 && cfg.tls.redirectPort <= 65535 && cfg.tls.redirectPort != cfg.port, "tls-redirect-port must be between 0 and 65535 and differ from port")
+
+	check(cfg.oidc.issuer == "" || cfg.oidc.clientID != "" && cfg.oidc.redirectURL != "", "oidc-client-id and oidc-redirect-url must be set together with oidc-issuer")
+
+	return errors.Join(errs...)
+}
+
+// print writes the configuration as a TOML file, which can be loaded back with -config.
+func (cfg *config) print(w io.Writer) error {
+	settings := make(map[string]any)
+
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		if cliOnlySettings[f.Name] {
+			return
+		}
+
+		var value any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			if v != "" && secretSettings[f.Name] {
+				value = "REDACTED"
+			}
+			if f.Name == "db-dsn" {
+				value = redactDSN(v)
+			}
+		}
+
+		settings[f.Name] = value
+	})
+
+	return toml.NewEncoder(w).Encode(settings)
+}
+
+// readConfigFile returns the settings in a TOML file, naming the keys of tables after the flags: the key
+// dsn in the table [db] becomes db-dsn.
+func readConfigFile(path string) (map[string]string, error) {
+	var tree map[string]any
+
+	_, err := toml.DecodeFile(path, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	flattenSettings("", tree, settings)
+
+	return settings, nil
+}
+
+func flattenSettings(prefix string, tree map[string]any, settings map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			flattenSettings(key, v, settings)
+		case []any:
+			items := make([]string, len(v))
+			for i := range v {
+				items[i] = fmt.Sprint(v[i])
+			}
+			settings[key] = strings.Join(items, " ")
+		default:
+			settings[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// envKey returns the environment variable of a setting, UKROP_DB_DSN for db-dsn.
+func envKey(name string) string {
+	return "UKROP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// redactDSN hides the password of a URL DSN, and the whole DSN when it's in the key=value format.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	if strings.Contains(dsn, "password") {
+		return "REDACTED"
+	}
+	return dsn
+}
+
+// fileMode is a file mode in octal.
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return errors.New("expected permission bits in octal, like 0660")
+	}
+
+	*m = fileMode(mode)
+	return nil
+}
+
+// stringList is a space separated list of values.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = strings.Fields(value)
+	return nil
+}
+
+func (l *stringList) Get() any {
+	return []string(*l)
+}
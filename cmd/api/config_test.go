@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestLoadConfigDefaults(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	// an empty DSN leaves the connection settings to lib/pq, which reads PGHOST, PGUSER and the rest
+	cfg, err := loadConfig(nil, noEnv)
+	if err != nil {
+		t.Fatalf("default configuration rejected: %s", err)
+	}
+	if cfg.db.dsn != "" {
+		t.Errorf("db-dsn = %q, want it empty", cfg.db.dsn)
+	}
+
+	_, err = loadConfig([]string{"-port", "0"}, noEnv)
+	if err == nil {
+		t.Error("port 0 accepted")
+	}
+}
+
+func TestLoadConfigVersion(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	cfg, err := loadConfig([]string{"-version", "-port", "0", "-config", "/nonexistent/api.toml"}, noEnv)
+	if err != nil {
+		t.Fatalf("-version failed: %s", err)
+	}
+	if !cfg.displayVersion {
+		t.Error("displayVersion not set")
+	}
+}
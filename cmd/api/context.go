@@ -9,9 +9,26 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	sessionContextKey     = contextKey("session")
+	requestInfoContextKey = contextKey("request_info")
+)
+
+// requestInfo is shared by the whole middleware chain, so that middleware wrapping the router can
+// see what got decided further down, like which route matched.
+type requestInfo struct {
+	requestID string
+	route     string // pattern of the matched route, empty when none matched
+	userID    int    // authenticated user, zero for anonymous requests
+}
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info := app.contextGetRequestInfo(r); info != nil {
+		info.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -24,3 +41,42 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+
+	return permissions
+}
+
+// contextSetSession stores the caller's authentication token or refresh token family, which
+// identifies the session the request was made with.
+func (app *application) contextSetSession(r *http.Request, session string) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// contextGetSession returns the caller's session, or an empty string for API keys and anonymous users.
+func (app *application) contextGetSession(r *http.Request) string {
+	session, _ := r.Context().Value(sessionContextKey).(string)
+	return session
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx), info
+}
+
+// contextGetRequestInfo returns nil when the request didn't pass through the middleware setting it.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
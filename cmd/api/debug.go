@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// pprofHandler serves the net/http/pprof endpoints under /debug/pprof/, which httprouter can only route
+// with a single catch-all. Note that CPU profiles and traces longer than the server's write timeout get
+// rejected, so ask for them with a short ?seconds=.
+func (app *application) pprofHandler(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("item") {
+	case "/cmdline":
+		pprof.Cmdline(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
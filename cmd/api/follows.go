@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/validator"
+)
+
+func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := app.readUsernameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	followed, err := app.models.Users.GetByUsername(r.Context(), username)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Follows.Insert(r.Context(), user.ID, followed.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateFollow):
+			app.errorResponse(w, r, http.StatusConflict, fmt.Sprintf("you are already following %s", followed.Username))
+		case errors.Is(err, data.ErrSelfFollow):
+			app.badRequestResponse(w, r, errors.New("you cannot follow yourself"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"following": followed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := app.readUsernameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	followed, err := app.models.Users.GetByUsername(r.Context(), username)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Follows.Delete(r.Context(), user.ID, followed.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": fmt.Sprintf("you are no longer following %s", followed.Username)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := app.readUsernameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByUsername(r.Context(), username)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	followers, err := app.models.Follows.GetFollowers(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"followers": followers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := app.readUsernameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByUsername(r.Context(), username)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	following, err := app.models.Follows.GetFollowing(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"following": following}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.KeysetFilters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	cursor, err := data.DecodeCursor(app.readString(qs, "cursor", ""))
+	if err != nil {
+		v.AddError("cursor", "must be a cursor returned by a previous page")
+	}
+	input.KeysetFilters.After = cursor
+	input.KeysetFilters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidateKeysetFilters(v, input.KeysetFilters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// same visibility rules as listRecommendationsHandler
+	user := app.contextGetUser(r)
+	privatePermissions := app.contextGetPermissions(r).Include("recommendations:write")
+
+	recommendations, metadata, err := app.models.Recommendations.GetFeed(r.Context(), user.ID, privatePermissions, input.KeysetFilters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendations": recommendations, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
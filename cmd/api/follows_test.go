@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api.ukrop.pl/internal/data"
+)
+
+func TestFeedRequiresReadPermission(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	router := app.router()
+
+	user := &data.User{ID: 1, Username: "user", Activated: true}
+
+	tests := []struct {
+		name        string
+		permissions data.Permissions
+		want        int
+	}{
+		{"without recommendations:read", data.Permissions{"comments:read", "recommendations:write"}, http.StatusForbidden},
+		// an invalid page size fails validation before the feed is queried, showing the request got through
+		{"with recommendations:read", data.Permissions{"recommendations:read"}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/feed?page_size=0", nil)
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, tt.permissions)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,50 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 )
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+const (
+	dependencyUp           = "up"
+	dependencyDown         = "down"
+	dependencyConfigured   = "configured" // credentials are set, but not probed
+	dependencyUnconfigured = "unconfigured"
+)
+
+// dependencyStatus is part of the public readiness response, so why a check failed only goes to the log.
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"-"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// dependencyCheck describes one dependency of the readiness check. configured reports whether the
+// dependency is set up at all, and probe is only run for it when it is.
+type dependencyCheck struct {
+	name       string
+	critical   bool
+	active     bool // probed only when active probes are enabled
+	configured func() bool
+	probe      func(ctx context.Context) error
+}
+
+type readinessReport struct {
+	status       string
+	dependencies map[string]dependencyStatus
+	checkedAt    time.Time
+}
+
+// readinessCache keeps the last report for a short while, so that frequent probes from load balancers
+// and orchestrators don't turn into a steady stream of database pings and SMTP handshakes.
+type readinessCache struct {
+	mu     sync.Mutex
+	report *readinessReport
+}
+
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
 		"system_info": map[string]string{
@@ -18,3 +58,142 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		env := envelope{"status": "shutting_down"}
+
+		err := app.writeJSON(w, http.StatusServiceUnavailable, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	report := app.readiness(r.Context())
+
+	status := http.StatusOK
+	if report.status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+
+	env := envelope{
+		"status":       report.status,
+		"dependencies": report.dependencies,
+		"checked_at":   report.checkedAt,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readiness returns the cached report, checking every dependency again once it gets too old. Concurrent
+// callers wait for a single round of checks instead of starting their own.
+func (app *application) readiness(ctx context.Context) *readinessReport {
+	app.health.mu.Lock()
+	defer app.health.mu.Unlock()
+
+	if app.health.report != nil && time.Since(app.health.report.checkedAt) < app.config.healthcheck.cacheTTL {
+		return app.health.report
+	}
+
+	checks := app.dependencyChecks()
+	statuses := make([]dependencyStatus, len(checks))
+
+	// the checks outlive the request that started them, since their result is shared with others
+	ctx = context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Go(func() {
+			statuses[i] = app.checkDependency(ctx, check)
+		})
+	}
+	wg.Wait()
+
+	report := &readinessReport{
+		status:       "available",
+		dependencies: make(map[string]dependencyStatus, len(checks)),
+		checkedAt:    time.Now(),
+	}
+
+	for i, check := range checks {
+		status := statuses[i]
+		report.dependencies[check.name] = status
+
+		switch {
+		case status.Status == dependencyUp || status.Status == dependencyConfigured:
+		case status.Critical:
+			report.status = "unavailable"
+		case report.status == "available":
+			report.status = "degraded"
+		}
+	}
+
+	app.health.report = report
+	return report
+}
+
+func (app *application) checkDependency(ctx context.Context, check dependencyCheck) dependencyStatus {
+	status := dependencyStatus{Critical: check.critical}
+
+	if !check.configured() {
+		status.Status = dependencyUnconfigured
+		return status
+	}
+
+	if check.active && !app.config.healthcheck.probe {
+		status.Status = dependencyConfigured
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, app.config.healthcheck.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.probe(ctx)
+	status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+	if err != nil {
+		app.logger.WarnContext(ctx, "health check failed", "dependency", check.name, "error", err)
+		status.Status = dependencyDown
+		return status
+	}
+
+	status.Status = dependencyUp
+	return status
+}
+
+func (app *application) dependencyChecks() []dependencyCheck {
+	return []dependencyCheck{
+		{
+			name:       "database",
+			critical:   true,
+			configured: func() bool { return true },
+			probe:      app.db.PingContext,
+		},
+		{
+			name:       "smtp",
+			configured: func() bool { return app.config.smtp.host != "" },
+			probe:      app.mailer.Ping,
+		},
+		{
+			name:       "youtube",
+			active:     true,
+			configured: func() bool { return app.config.yt.apiKey != "" },
+			probe:      app.youtube.Ping,
+		},
+		{
+			name:       "spotify",
+			active:     true,
+			configured: func() bool { return app.config.sp.clientID != "" && app.config.sp.clientSecret != "" },
+			probe:      app.spotify.Ping,
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckDependencyHidesError(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.healthcheck.timeout = time.Second
+
+	check := dependencyCheck{
+		name:       "database",
+		critical:   true,
+		configured: func() bool { return true },
+		probe: func(ctx context.Context) error {
+			return errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")
+		},
+	}
+
+	status := app.checkDependency(context.Background(), check)
+	if status.Status != dependencyDown || !status.Critical {
+		t.Fatalf("status = %+v, want a critical dependency down", status)
+	}
+
+	js, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(js), "10.0.0.5") {
+		t.Errorf("public status %s reveals the error", js)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// systemdFirstFD is the first file descriptor passed by systemd socket activation, after stdin, stdout
+// and stderr.
+const systemdFirstFD = 3
+
+// listen returns the listener of the API: a socket inherited through systemd socket activation, the Unix
+// socket given with -socket, or TCP on -port. With socket activation systemd keeps the socket open while
+// the service restarts, so no connection gets refused in between.
+func (app *application) listen() (net.Listener, error) {
+	ln, err := systemdListener(systemdFirstFD)
+	if err != nil || ln != nil {
+		return ln, err
+	}
+
+	if app.config.socket.path == "" {
+		return net.Listen("tcp", fmt.Sprintf(":%d", app.config.port))
+	}
+
+	// a socket left behind by a process that didn't exit cleanly would make listening fail, but one still
+	// answering belongs to a live instance which mustn't lose its path
+	info, err := os.Lstat(app.config.socket.path)
+	if err == nil && info.Mode().Type() == fs.ModeSocket {
+		conn, err := net.Dial("unix", app.config.socket.path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen unix %s: %w", app.config.socket.path, syscall.EADDRINUSE)
+		}
+
+		err = os.Remove(app.config.socket.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err = net.Listen("unix", app.config.socket.path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(app.config.socket.path, os.FileMode(app.config.socket.mode))
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// systemdListener returns the socket passed by systemd at firstFD, or nil when the process wasn't socket
+// activated. See sd_listen_fds(3).
+func systemdListener(firstFD int) (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, expected 1", fds)
+	}
+
+	// the variables are meant for this process only
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(firstFD), "systemd-socket")
+	if f == nil {
+		return nil, errors.New("systemd socket file descriptor is invalid")
+	}
+	defer f.Close()
+
+	return net.FileListener(f)
+}
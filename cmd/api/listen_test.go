@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func newSocketTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	// systemd variables left in the environment would take precedence over the socket
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	app := &application{}
+	app.config.socket.path = filepath.Join(t.TempDir(), "api.sock")
+	app.config.socket.mode = 0o600
+
+	return app
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	app := newSocketTestApplication(t)
+
+	ln, err := app.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	info, err := os.Stat(app.config.socket.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != os.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %s, want a socket with 0600", info.Mode())
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("unix", app.config.socket.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenUnixSocketInUse(t *testing.T) {
+	app := newSocketTestApplication(t)
+
+	ln, err := app.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	_, err = app.listen()
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("err = %v, want %v", err, syscall.EADDRINUSE)
+	}
+
+	if _, err := os.Stat(app.config.socket.path); err != nil {
+		t.Errorf("socket of the running listener removed: %s", err)
+	}
+}
+
+func TestListenUnixSocketStale(t *testing.T) {
+	app := newSocketTestApplication(t)
+
+	ln, err := net.Listen("unix", app.config.socket.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as if the process died without cleaning up
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = app.listen()
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %s", err)
+	}
+	ln.Close()
+}
+
+func TestSystemdListenerEnvironment(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name    string
+		pid     string
+		fds     string
+		wantErr bool
+	}{
+		{"not activated", "", "", false},
+		{"another process", strconv.Itoa(os.Getpid() + 1), "1", false},
+		{"invalid pid", "systemd", "1", false},
+		{"no sockets", pid, "0", false},
+		{"invalid count", pid, "one", false},
+		{"several sockets", pid, "2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			// none of these get as far as the file descriptor, an invalid one would fail loudly if they did
+			ln, err := systemdListener(-1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if ln != nil {
+				ln.Close()
+				t.Error("listener returned")
+			}
+		})
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	// hand over a duplicate of the socket the way systemd would, as a descriptor no *os.File owns
+	f, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	ln, err := systemdListener(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if ln.Addr().String() != tcp.Addr().String() {
+		t.Errorf("address = %s, want %s", ln.Addr(), tcp.Addr())
+	}
+
+	if _, set := os.LookupEnv("LISTEN_FDS"); set {
+		t.Error("LISTEN_FDS left in the environment")
+	}
+}
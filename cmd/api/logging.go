@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+// requestIDRX accepts the IDs proxies and clients commonly send, without letting anything odd into the logs.
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9\-_.:]{1,128}$`)
+
+// newLogger builds the application logger. Records logged with a request context carry its request ID.
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+
+	return slog.New(requestLogHandler{handler}), nil
+}
+
+// requestLogHandler adds the request ID from the context to every record logged with one.
+type requestLogHandler struct {
+	slog.Handler
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		record.AddAttrs(slog.String("request_id", info.requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
+}
+
+// logRequest assigns every request an ID, reusing a valid X-Request-ID sent by the client or a proxy,
+// and writes one access log record once the response has been sent.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		r, info := app.contextSetRequestInfo(r)
+
+		info.requestID = r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(info.requestID) {
+			info.requestID = rand.Text()
+		}
+		w.Header().Set("X-Request-ID", info.requestID)
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", routeLabel(info)),
+			slog.Int("status", mw.statusCode),
+			slog.Int64("bytes", mw.bytesWritten),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", realip.FromRequest(r)),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.userID))
+		}
+
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
@@ -3,118 +3,94 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"api.ukrop.pl/internal/data"
 	"api.ukrop.pl/internal/mailer"
+	"api.ukrop.pl/internal/oidc"
 	"api.ukrop.pl/internal/spotify"
 	"api.ukrop.pl/internal/vcs"
 	"api.ukrop.pl/internal/youtube"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 var (
 	version = vcs.Version()
 )
 
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  time.Duration
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-	yt struct {
-		apiKey     string
-		maxResults int
-	}
-	sp struct {
-		clientID     string
-		clientSecret string
-		maxResults   int
-	}
-	cors struct {
-		trustedOrigins []string
-	}
-}
-
 type application struct {
-	config  config
-	logger  *slog.Logger
-	models  data.Models
-	mailer  *mailer.Mailer
-	youtube *youtube.Client
-	spotify *spotify.Client
-	wg      sync.WaitGroup
+	config       config                 // as loaded at startup, read reloadable settings from current
+	current      atomic.Pointer[config] // with the reloadable settings swapped on SIGHUP
+	logger       *slog.Logger
+	logLevel     *slog.LevelVar
+	db           *sql.DB // used directly only by the readiness check, everything else goes through models
+	models       data.Models
+	mailer       *mailer.Mailer
+	youtube      *youtube.Client
+	spotify      *spotify.Client
+	logins       *loginThrottle
+	oidc         *oidc.Client // nil when no identity provider is configured
+	oidcLogins   *oidcLogins
+	health       readinessCache
+	shuttingDown atomic.Bool // set once shutdown begins, failing readiness checks
+	wg           sync.WaitGroup
 }
 
 func main() {
-	var cfg config
-
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 20, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("UKROP_SMTP_HOST"), "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("UKROP_SMTP_USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("UKROP_SMTP_PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Ukrop <no-reply@ukrop.pl>", "SMTP sender")
-
-	flag.StringVar(&cfg.yt.apiKey, "yt-api-key", os.Getenv("YOUTUBE_API_KEY"), "Api key for Youtube Data")
-	flag.IntVar(&cfg.yt.maxResults, "yt-max-resuts", 5, "Max queries returned by Youtube api at once")
-
-	flag.StringVar(&cfg.sp.clientID, "sp-client-id", os.Getenv("SPOTIFY_CLIENT_ID"), "Client ID for Spotify")
-	flag.StringVar(&cfg.sp.clientSecret, "sp-client-secret", os.Getenv("SPOTIFY_CLIENT_SECRET"), "Client Secret for Spotify")
-	flag.IntVar(&cfg.sp.maxResults, "sp-max-resuts", 5, "Max queries returned by Spotify api at once")
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separate)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	if cfg.displayVersion {
+		fmt.Printf("Version:\t%s\n", version)
+		os.Exit(0)
+	}
 
-	displayVersion := flag.Bool("version", false, "Display version and exit")
+	if cfg.printConfig {
+		err = cfg.print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	flag.Parse()
+	logLevel := new(slog.LevelVar)
+	err = logLevel.UnmarshalText([]byte(cfg.log.level))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	if *displayVersion {
-		fmt.Printf("Version:\t%s\n", version)
-		os.Exit(0)
+	logger, err := newLogger(os.Stdout, cfg.log.format, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	shutdownTracing, err := setupTracing(*cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	db, err := openDB(cfg)
+	db, err := openDB(*cfg)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -122,6 +98,19 @@ func main() {
 	defer db.Close()
 	logger.Info("database connection pool established")
 
+	models := data.NewModels(db)
+	if cfg.permissions.cacheTTL > 0 {
+		models.EnablePermissionCache(cfg.permissions.cacheTTL)
+	}
+
+	if cfg.users.defaultRole != "" {
+		_, err = models.Roles.Get(context.Background(), cfg.users.defaultRole)
+		if err != nil {
+			logger.Error(fmt.Sprintf("invalid default role %q: %s", cfg.users.defaultRole, err))
+			os.Exit(1)
+		}
+	}
+
 	m, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
 	if err != nil {
 		logger.Error(err.Error())
@@ -140,6 +129,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	var provider *oidc.Client
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err = oidc.New(ctx, cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL, cfg.env == "development")
+		cancel()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// ======== EXPVAR ========
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -148,25 +148,40 @@ func main() {
 	expvar.Publish("database", expvar.Func(func() any {
 		return db.Stats()
 	}))
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "ukrop"))
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
 	}))
 	// ======== END EXPVAR ========
 
 	app := &application{
-		config:  cfg,
-		logger:  logger,
-		models:  data.NewModels(db),
-		mailer:  m,
-		youtube: yt,
-		spotify: sp,
+		config:     *cfg,
+		logger:     logger,
+		logLevel:   logLevel,
+		db:         db,
+		models:     models,
+		mailer:     m,
+		youtube:    yt,
+		spotify:    sp,
+		logins:     newLoginThrottle(cfg.login.maxAttempts, cfg.login.lockout, cfg.login.maxLockout),
+		oidc:       provider,
+		oidcLogins: newOIDCLogins(),
 	}
 
+	app.current.Store(cfg)
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+
+	// send the spans still buffered in the batcher
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
 
 func openDB(cfg config) (*sql.DB, error) {
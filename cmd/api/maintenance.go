@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// maintenanceJob is periodic housekeeping. run returns the number of rows it touched.
+type maintenanceJob struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) (int64, error)
+}
+
+func (app *application) maintenanceJobs() []maintenanceJob {
+	interval := app.config.maintenance.interval
+
+	jobs := []maintenanceJob{
+		// session and API key last-used times are written in bulk instead of on every request
+		{name: "session_last_used", interval: time.Minute, run: app.models.Tokens.LastUsed.Flush},
+		{name: "expired_tokens", interval: interval, run: app.models.Tokens.DeleteExpired},
+	}
+
+	// deleting accounts is opt-in: set users-unactivated-grace in the config file, UKROP_USERS_UNACTIVATED_GRACE
+	// or -users-unactivated-grace to how long registrations get to be activated, e.g. 168h
+	if grace := app.config.maintenance.unactivatedGrace; grace > 0 {
+		jobs = append(jobs, maintenanceJob{name: "stale_unactivated_users", interval: interval, run: func(ctx context.Context) (int64, error) {
+			return app.models.Users.DeleteStaleUnactivated(ctx, grace)
+		}})
+	}
+
+	return jobs
+}
+
+// runMaintenance starts every job on its own schedule until ctx is cancelled. The jobs are tracked by
+// app.wg, so shutdown waits for runs in progress to finish.
+func (app *application) runMaintenance(ctx context.Context) {
+	var (
+		maintenanceRuns    = expvar.NewMap("maintenance_runs")
+		maintenanceErrors  = expvar.NewMap("maintenance_errors")
+		maintenanceRows    = expvar.NewMap("maintenance_rows")
+		maintenanceLastRun = expvar.NewMap("maintenance_last_run")
+	)
+
+	for _, job := range app.maintenanceJobs() {
+		app.wg.Go(func() {
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				rows, err := job.run(ctx)
+
+				maintenanceRuns.Add(job.name, 1)
+				lastRun := new(expvar.Int)
+				lastRun.Set(time.Now().Unix())
+				maintenanceLastRun.Set(job.name, lastRun)
+
+				if err != nil {
+					maintenanceErrors.Add(job.name, 1)
+					app.logger.Error(fmt.Sprintf("maintenance job %s failed: %s", job.name, err))
+					continue
+				}
+
+				maintenanceRows.Add(job.name, rows)
+				if rows > 0 {
+					app.logger.Debug(fmt.Sprintf("maintenance job %s affected %d rows", job.name, rows))
+				}
+			}
+		})
+	}
+}
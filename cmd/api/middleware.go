@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/jwt"
 	"api.ukrop.pl/internal/validator"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
@@ -31,10 +32,6 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 }
 
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	if !app.config.limiter.enabled {
-		return next
-	}
-
 	type client struct {
 		limiter  *rate.Limiter
 		lastSeen time.Time
@@ -59,18 +56,32 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.current.Load().limiter
+		if !cfg.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		ip := realip.FromRequest(r)
+		limit := rate.Limit(cfg.rps)
 
 		mu.Lock()
 
 		if _, found := clients[ip]; !found {
-			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			clients[ip] = &client{limiter: rate.NewLimiter(limit, cfg.burst)}
+		}
+
+		// the limits may have been reloaded since the client's limiter was created
+		if clients[ip].limiter.Limit() != limit || clients[ip].limiter.Burst() != cfg.burst {
+			clients[ip].limiter.SetLimit(limit)
+			clients[ip].limiter.SetBurst(cfg.burst)
 		}
 
 		clients[ip].lastSeen = time.Now()
 
 		if !clients[ip].limiter.Allow() {
 			mu.Unlock()
+			rateLimitRejections.WithLabelValues("ip").Inc()
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
@@ -82,10 +93,16 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization") // indicate to the cache that response may vary based on the Auth header
+		w.Header().Add("Vary", "X-API-Key")
 
 		authorizationHeader := r.Header.Get("Authorization")
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" && authorizationHeader == "" {
+			authorizationHeader = "Bearer " + apiKey // scripts may send the key on its own header
+		}
+
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetPermissions(r, data.Permissions{})
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -98,6 +115,58 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1] // extract the actual token
 
+		if data.IsAPIKey(token) {
+			v := validator.New()
+
+			if data.ValidateAPIKeyPlaintext(v, token); !v.Valid() {
+				app.invalidCredentialsResponse(w, r)
+				return
+			}
+
+			user, permissions, err := app.models.APIKeys.GetUserForKey(r.Context(), token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			app.models.APIKeys.LastUsed.RecordAPIKey(token)
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, permissions)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.config.jwt.enabled() && jwt.LooksLikeJWT(token) {
+			claims, err := jwt.Parse(token, []byte(app.config.jwt.secret), app.config.jwt.issuer, time.Now())
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			id, err := strconv.Atoi(claims.Subject)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// stateless: the user is rebuilt from the claims without touching the database
+			user := &data.User{ID: id, Name: claims.Name, Username: claims.Username, Activated: claims.Activated}
+
+			app.models.Tokens.LastUsed.RecordFamily(claims.SessionID)
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, claims.Permissions)
+			r = app.contextSetSession(r, claims.SessionID)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -105,7 +174,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token) // get the user
+		user, permissions, err := app.models.Users.GetForTokenWithPermissions(r.Context(), data.ScopeAuthentication, token) // get the user
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -116,7 +185,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		app.models.Tokens.LastUsed.Record(token)
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetPermissions(r, permissions)
+		r = app.contextSetSession(r, token)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -149,16 +222,25 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
-func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+// requireSession rejects requests authenticated by an API key rather than a signed in session, keeping
+// keys away from the account itself: its other keys, sessions, second factor, email and deletion.
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
+		if app.contextGetSession(r) == "" {
+			app.sessionRequiredResponse(w, r)
 			return
 		}
 
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permissions := app.contextGetPermissions(r)
+
 		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
@@ -177,13 +259,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		origin := r.Header.Get("Origin")
 		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
+			trustedOrigins := app.current.Load().cors.trustedOrigins
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" { // handle preflight CORS
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
 
 						w.WriteHeader(http.StatusOK)
 						return // simply return from middleware, do not even pass to other middlewares
@@ -200,6 +283,7 @@ type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int64
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
@@ -224,7 +308,10 @@ func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
 
 func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
 	mw.headerWritten = true
-	return mw.wrapped.Write(b)
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += int64(n)
+	return n, err
 }
 
 func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
@@ -243,13 +330,21 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		start := time.Now()
 
 		totalRequestsReceived.Add(1)
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
 		mw := newMetricsResponseWriter(w)
+		info := app.contextGetRequestInfo(r)
 
 		next.ServeHTTP(mw, r)
 		totalResponsesSent.Add(1)
 		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
 
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		route, method := routeLabel(info), methodLabel(r.Method)
+		httpRequestDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+		httpResponses.WithLabelValues(route, method, statusClassLabel(mw.statusCode)).Inc()
 	})
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api.ukrop.pl/internal/data"
+)
+
+func TestRequireSession(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	user := &data.User{ID: 1, Username: "user", Activated: true}
+
+	tests := []struct {
+		name    string
+		user    *data.User
+		session string
+		want    int
+	}{
+		{"session", user, "session", http.StatusOK},
+		{"api key", user, "", http.StatusForbidden},
+		{"anonymous", data.AnonymousUser, "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api-keys", nil)
+			r = app.contextSetUser(r, tt.user)
+			r = app.contextSetPermissions(r, data.Permissions{})
+			if tt.session != "" {
+				r = app.contextSetSession(r, tt.session)
+			}
+
+			rr := httptest.NewRecorder()
+			app.requireSession(func(w http.ResponseWriter, r *http.Request) {})(rr, r)
+
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+// TestAPIKeyDeniedRoutes checks that the account management routes turn away a caller without a
+// session, which is how requests authenticated by an API key arrive.
+func TestAPIKeyDeniedRoutes(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	router := app.router()
+
+	user := &data.User{ID: 1, Username: "user", Activated: true}
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/v1/api-keys"},
+		{http.MethodPost, "/v1/api-keys"},
+		{http.MethodDelete, "/v1/api-keys/1"},
+		{http.MethodPost, "/v1/users/me/totp"},
+		{http.MethodPut, "/v1/users/me/totp"},
+		{http.MethodDelete, "/v1/users/me/totp"},
+		{http.MethodPatch, "/v1/users/me"},
+		{http.MethodDelete, "/v1/users/me"},
+		{http.MethodGet, "/v1/tokens/authentication"},
+		{http.MethodDelete, "/v1/tokens/authentication/1"},
+	}
+
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			r := httptest.NewRequest(route.method, route.path, nil)
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, data.Permissions{adminPermission})
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", rr.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
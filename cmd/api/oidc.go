@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/validator"
+	"golang.org/x/oauth2"
+)
+
+// oidcLogin is a sign-in started at the provider and waiting for the user to come back with a code.
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	expiry   time.Time
+}
+
+// oidcLogins keeps pending sign-ins by their state parameter. They live in memory, so the code has to
+// be exchanged with the same instance that handed out the authorization URL.
+type oidcLogins struct {
+	mu     sync.Mutex
+	logins map[string]oidcLogin
+}
+
+func newOIDCLogins() *oidcLogins {
+	l := &oidcLogins{logins: make(map[string]oidcLogin)}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+
+			for state, login := range l.logins {
+				if time.Now().After(login.expiry) {
+					delete(l.logins, state)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+func (l *oidcLogins) add(state string, login oidcLogin) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.logins[state] = login
+}
+
+// take removes the pending sign-in, so every state can be used once.
+func (l *oidcLogins) take(state string) (oidcLogin, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	login, found := l.logins[state]
+	delete(l.logins, state)
+
+	return login, found && time.Now().Before(login.expiry)
+}
+
+func (app *application) oidcAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state := rand.Text()
+	login := oidcLogin{
+		nonce:    rand.Text(),
+		verifier: oauth2.GenerateVerifier(),
+		expiry:   time.Now().Add(10 * time.Minute),
+	}
+
+	app.oidcLogins.add(state, login)
+
+	env := envelope{
+		"authorization_url": app.oidc.AuthCodeURL(state, login.nonce, login.verifier),
+		"state":             state,
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOIDCAuthenticationTokenHandler finishes a sign-in with the code the provider redirected the user
+// back with. The account is found by the verified email, or created already activated.
+func (app *application) createOIDCAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, ok := app.oidcLogins.take(input.State)
+	if !ok {
+		v.AddError("state", "invalid or expired state")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), input.Code, login.verifier, login.nonce)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), err.Error())
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		app.badRequestResponse(w, r, errors.New("the identity provider did not confirm a verified email address"))
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), claims.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.registerOIDCUser(r.Context(), claims.Email, claims.Name, claims.PreferredUsername)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case !user.Activated:
+		// the provider vouched for the email, which is what activation proves
+		user.Activated = true
+
+		err = app.models.Users.Update(r.Context(), user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if app.challengeSecondFactor(w, r, user) {
+		return
+	}
+
+	app.writeAuthenticationTokens(w, r, user)
+}
+
+// registerOIDCUser creates an activated account for a first-time sign-in. The username is derived from
+// the provider's preferred username or the email, with a random suffix when it is taken.
+func (app *application) registerOIDCUser(ctx context.Context, email, name, preferredUsername string) (*data.User, error) {
+	base := oidcUsername(preferredUsername)
+	if base == "" {
+		base = oidcUsername(strings.Split(email, "@")[0])
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	if name == "" {
+		name = base
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: true,
+	}
+
+	// nobody knows the password, it can be set later through a password reset
+	err := user.Password.Set(rand.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := range 5 {
+		user.Username = base
+		if attempt > 0 {
+			user.Username = fmt.Sprintf("%s-%s", base[:min(len(base), 57)], strings.ToLower(rand.Text()[:6]))
+		}
+
+		err = app.models.Users.Insert(ctx, user)
+		if !errors.Is(err, data.ErrDuplicateUsername) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if app.config.users.defaultRole != "" {
+		err = app.models.Roles.AddForUser(ctx, user.ID, app.config.users.defaultRole)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.logger.InfoContext(ctx, fmt.Sprintf("registered user %s through the identity provider", user.Username))
+
+	return user, nil
+}
+
+// oidcUsername turns s into a valid username, or returns an empty string if too little of it is left.
+func oidcUsername(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteRune(c)
+		case c == '.' || c == ' ':
+			b.WriteRune('_')
+		}
+	}
+
+	username := strings.Trim(b.String(), "-_")
+	username = strings.TrimRight(username[:min(len(username), 64)], "-_")
+
+	v := validator.New()
+	if data.ValidateUsername(v, username); !v.Valid() {
+		return ""
+	}
+	return username
+}
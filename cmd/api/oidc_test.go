@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/oidc"
+	"api.ukrop.pl/internal/oidc/oidctest"
+)
+
+// signInWithOIDC goes through the whole sign-in against the stand-in provider: asking for the
+// authorization URL, signing in there as identity and exchanging the code the user comes back with.
+func signInWithOIDC(t *testing.T, app *application, provider *oidctest.Provider, identity oidctest.Identity) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	app.oidcAuthorizeHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/oidc/authorize", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("authorize status = %d: %s", rr.Code, rr.Body)
+	}
+
+	var authorization struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := provider.Authorize(authorization.AuthorizationURL, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"code": %q, "state": %q}`, code, authorization.State)
+
+	rr = httptest.NewRecorder()
+	app.createOIDCAuthenticationTokenHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/oidc", strings.NewReader(body)))
+	return rr
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	var err error
+	app.oidc, err = oidc.New(ctx, provider.URL, "client", "secret", "https://app.example.com/callback", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.oidcLogins = newOIDCLogins()
+
+	// registered, but never activated, which the provider's verified email takes care of
+	existing := insertTestUser(t, app, "existing", false)
+
+	tests := []struct {
+		name         string
+		identity     oidctest.Identity
+		wantStatus   int
+		wantUserID   int // 0 for a new account
+		wantUsername string
+	}{
+		{
+			name:         "links account by email",
+			identity:     oidctest.Identity{Subject: "1", Email: existing.Email, EmailVerified: true, PreferredUsername: "someone-else"},
+			wantStatus:   http.StatusCreated,
+			wantUserID:   existing.ID,
+			wantUsername: existing.Username,
+		},
+		{
+			name:         "creates account",
+			identity:     oidctest.Identity{Subject: "2", Email: "new@example.com", EmailVerified: true, Name: "New User", PreferredUsername: "new.user"},
+			wantStatus:   http.StatusCreated,
+			wantUsername: "new_user",
+		},
+		{
+			name:         "creates account with a free username",
+			identity:     oidctest.Identity{Subject: "3", Email: "other@example.com", EmailVerified: true, PreferredUsername: "existing"},
+			wantStatus:   http.StatusCreated,
+			wantUsername: "existing-",
+		},
+		{
+			name:       "unverified email",
+			identity:   oidctest.Identity{Subject: "4", Email: "unverified@example.com"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := signInWithOIDC(t, app, provider, tt.identity)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			user, err := app.models.Users.GetByEmail(ctx, tt.identity.Email)
+
+			if tt.wantStatus != http.StatusCreated {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					t.Errorf("account created for %s", tt.identity.Email)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var response struct {
+				User struct {
+					ID int `json:"id"`
+				} `json:"user"`
+			}
+			err = json.Unmarshal(rr.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case response.User.ID != user.ID:
+				t.Errorf("signed in as user %d, want %d", response.User.ID, user.ID)
+			case tt.wantUserID != 0 && user.ID != tt.wantUserID:
+				t.Errorf("user = %d, want the existing user %d", user.ID, tt.wantUserID)
+			case !strings.HasPrefix(user.Username, tt.wantUsername):
+				t.Errorf("username = %q, want %q", user.Username, tt.wantUsername)
+			case !user.Activated:
+				t.Error("user isn't activated")
+			}
+		})
+	}
+}
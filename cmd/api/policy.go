@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"api.ukrop.pl/internal/data"
+)
+
+// adminPermission lets its holders act on any resource regardless of the resource policy.
+const adminPermission = "users:manage"
+
+// policy decides who, apart from admins, may modify or delete a resource.
+type policy struct {
+	resource   string
+	allowOwner bool
+	permission string // holders may act on resources they don't own, empty for none
+}
+
+var (
+	recommendationPolicy = policy{resource: "recommendation", allowOwner: true, permission: "recommendations:moderate"}
+	commentPolicy        = policy{resource: "comment", allowOwner: true, permission: "comments:moderate"}
+	reservationPolicy    = policy{resource: "reservation", allowOwner: true, permission: "reservations:moderate"}
+)
+
+func (p policy) allows(user *data.User, permissions data.Permissions, ownerID int) bool {
+	switch {
+	case user.IsAnonymous():
+		return false
+	case p.allowOwner && user.ID == ownerID:
+		return true
+	case p.permission != "" && permissions.Include(p.permission):
+		return true
+	default:
+		return permissions.Include(adminPermission)
+	}
+}
+
+// authorize checks the policy for the user in the request context. When access is denied it writes a
+// not found response, so the caller learns nothing about resources it cannot touch, and returns false.
+func (app *application) authorize(w http.ResponseWriter, r *http.Request, p policy, resourceID, ownerID int) bool {
+	user := app.contextGetUser(r)
+
+	if !p.allows(user, app.contextGetPermissions(r), ownerID) {
+		app.logger.WarnContext(r.Context(), fmt.Sprintf("user %s is not allowed to modify %s %d", user.Username, p.resource, resourceID))
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	owner := &data.User{ID: 1, Username: "owner"}
+	other := &data.User{ID: 2, Username: "other"}
+
+	tests := []struct {
+		name        string
+		policy      policy
+		user        *data.User
+		permissions data.Permissions
+		want        bool
+	}{
+		{"owner", recommendationPolicy, owner, data.Permissions{"recommendations:write"}, true},
+		{"owner without permissions", recommendationPolicy, owner, nil, true},
+		{"non-owner", recommendationPolicy, other, data.Permissions{"recommendations:write"}, false},
+		{"non-owner with another resource's permission", recommendationPolicy, other, data.Permissions{"comments:moderate"}, false},
+		{"permission holder", recommendationPolicy, other, data.Permissions{"recommendations:moderate"}, true},
+		{"comment permission holder", commentPolicy, other, data.Permissions{"comments:moderate"}, true},
+		{"reservation permission holder", reservationPolicy, other, data.Permissions{"reservations:moderate"}, true},
+		{"admin", reservationPolicy, other, data.Permissions{adminPermission}, true},
+		{"anonymous", commentPolicy, data.AnonymousUser, data.Permissions{"comments:moderate", adminPermission}, false},
+		{"owner not allowed", policy{resource: "thing", permission: "things:moderate"}, owner, nil, false},
+		{"owner not allowed, permission holder", policy{resource: "thing", permission: "things:moderate"}, owner, data.Permissions{"things:moderate"}, true},
+		{"no permission, admin", policy{resource: "thing"}, other, data.Permissions{adminPermission}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.allows(tt.user, tt.permissions, owner.ID)
+			if got != tt.want {
+				t.Errorf("allows() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNonOwnerCannotModify checks the handlers actually apply their policy: a user allowed to write the
+// resource type, but not owning the resource, gets a not found response and the row stays as it was.
+func TestNonOwnerCannotModify(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	owner := insertTestUser(t, app, "owner", true)
+	other := insertTestUser(t, app, "other", true)
+
+	recommendation := &data.Recommendation{UserID: owner.ID, Artist: "Artist", Title: "Title", YTLink: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", IsPublic: true}
+	err := app.models.Recommendations.Insert(ctx, recommendation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comment := &data.Comment{RecommendationID: recommendation.ID, UserID: owner.ID, Content: "Content"}
+	err = app.models.Comments.Insert(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	reservation := &data.Reservation{UserID: owner.ID, Title: "Title", StartTime: start, EndTime: start.Add(time.Hour)}
+	err = app.models.Reservations.Insert(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions := data.Permissions{"recommendations:write", "comments:write", "reservations:write"}
+
+	resources := []struct {
+		name   string
+		id     int
+		update http.HandlerFunc
+		delete http.HandlerFunc
+		body   string
+		get    func() (any, error)
+	}{
+		{
+			name:   "recommendation",
+			id:     recommendation.ID,
+			update: app.updateRecommendationHandler,
+			delete: app.deleteRecommendationHandler,
+			body:   `{"title": "Changed"}`,
+			get:    func() (any, error) { return app.models.Recommendations.Get(ctx, recommendation.ID) },
+		},
+		{
+			name:   "comment",
+			id:     comment.ID,
+			update: app.updateCommentHandler,
+			delete: app.deleteCommentHandler,
+			body:   `{"content": "Changed"}`,
+			get:    func() (any, error) { return app.models.Comments.Get(ctx, comment.ID) },
+		},
+		{
+			name:   "reservation",
+			id:     reservation.ID,
+			update: app.updateReservationHandler,
+			delete: app.deleteReservationHandler,
+			body:   `{"title": "Changed"}`,
+			get:    func() (any, error) { return app.models.Reservations.Get(ctx, reservation.ID) },
+		},
+	}
+
+	for _, res := range resources {
+		before, err := res.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+			t.Run(res.name+" "+method, func(t *testing.T) {
+				handler := res.update
+				if method == http.MethodDelete {
+					handler = res.delete
+				}
+
+				rr := serveAs(app, handler, other, permissions, method, res.id, res.body)
+				if rr.Code != http.StatusNotFound {
+					t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+				}
+
+				after, err := res.get()
+				if err != nil {
+					t.Fatalf("%s is gone: %s", res.name, err)
+				}
+				if !reflect.DeepEqual(before, after) {
+					t.Errorf("%s changed from %+v to %+v", res.name, before, after)
+				}
+			})
+		}
+
+		// the owner going through the same handler succeeds, so the 404 above came from the policy
+		t.Run(res.name+" owner", func(t *testing.T) {
+			rr := serveAs(app, res.update, owner, permissions, http.MethodPatch, res.id, res.body)
+			if rr.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+		})
+	}
+}
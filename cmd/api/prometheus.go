@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics served on /metrics next to the expvar totals on /debug/vars.
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ukrop_http_request_duration_seconds",
+		Help: "Time taken to serve requests, by route pattern and method.",
+	}, []string{"route", "method"})
+
+	httpResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ukrop_http_responses_total",
+		Help: "Responses sent, by route pattern, method and status class.",
+	}, []string{"route", "method", "status_class"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ukrop_http_requests_in_flight",
+		Help: "Requests currently being served.",
+	})
+
+	rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ukrop_rate_limit_rejections_total",
+		Help: "Requests rejected by a limiter, by limiter.",
+	}, []string{"limiter"})
+
+	searchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ukrop_search_duration_seconds",
+		Help: "Latency of external music searches, by provider and result.",
+	}, []string{"provider", "result"})
+)
+
+// routeLabel names unmatched requests, which have no pattern, with a single label value.
+func routeLabel(info *requestInfo) string {
+	if info == nil || info.route == "" {
+		return "unmatched"
+	}
+	return info.route
+}
+
+// methodLabel folds non-standard methods into one label value, clients can send anything there.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+func statusClassLabel(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	case status >= 200:
+		return "2xx"
+	default:
+		return "1xx"
+	}
+}
+
+// resultLabel is "error" or "success" depending on err.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -48,7 +48,7 @@ func (app *application) createRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = app.models.Recommendations.Insert(recommendation)
+	err = app.models.Recommendations.Insert(r.Context(), recommendation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -61,7 +61,7 @@ func (app *application) createRecommendationHandler(w http.ResponseWriter, r *ht
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-	app.logger.Info(fmt.Sprintf("Recommendation created by %s", user.Username))
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("Recommendation created by %s", user.Username))
 }
 
 func (app *application) showRecommendationHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,7 +71,7 @@ func (app *application) showRecommendationHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	recommendation, err := app.models.Recommendations.Get(id)
+	recommendation, err := app.models.Recommendations.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -82,7 +82,7 @@ func (app *application) showRecommendationHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	comments, err := app.models.Comments.GetForRecommendation(recommendation.ID)
+	comments, err := app.models.Comments.GetForRecommendation(r.Context(), recommendation.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -103,7 +103,7 @@ func (app *application) updateRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	recommendation, err := app.models.Recommendations.Get(id)
+	recommendation, err := app.models.Recommendations.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -114,10 +114,8 @@ func (app *application) updateRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	user := app.contextGetUser(r)
-	if recommendation.UserID != user.ID {
-		app.logger.Warn(fmt.Sprintf("user %s is not the owner of %d recommendation", user.Username, recommendation.ID))
-		app.notFoundResponse(w, r)
+	if !app.authorize(w, r, recommendationPolicy, recommendation.ID, recommendation.UserID) {
+		return
 	}
 
 	var input struct {
@@ -164,7 +162,7 @@ func (app *application) updateRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = app.models.Recommendations.Update(recommendation)
+	err = app.models.Recommendations.Update(r.Context(), recommendation)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -188,7 +186,7 @@ func (app *application) deleteRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	recommendation, err := app.models.Recommendations.Get(id)
+	recommendation, err := app.models.Recommendations.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -199,13 +197,11 @@ func (app *application) deleteRecommendationHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	user := app.contextGetUser(r)
-	if recommendation.UserID != user.ID {
-		app.logger.Warn(fmt.Sprintf("user %s is not the owner of %d recommendation", user.Username, recommendation.ID))
-		app.notFoundResponse(w, r)
+	if !app.authorize(w, r, recommendationPolicy, recommendation.ID, recommendation.UserID) {
+		return
 	}
 
-	err = app.models.Recommendations.Delete(id)
+	err = app.models.Recommendations.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -248,15 +244,9 @@ func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *htt
 	}
 
 	// check for public permissions
-	user := app.contextGetUser(r)
-	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	privatePermissions := permissions.Include("recommendations:write")
+	privatePermissions := app.contextGetPermissions(r).Include("recommendations:write")
 
-	recommendations, metadata, err := app.models.Recommendations.GetAll(input.CreatedAt, input.CreatedBy, input.Title, privatePermissions, input.Filters)
+	recommendations, metadata, err := app.models.Recommendations.GetAll(r.Context(), input.CreatedAt, input.CreatedBy, input.Title, privatePermissions, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
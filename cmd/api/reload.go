@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"os"
+)
+
+// reloadableSettings can be changed on a running server by sending it SIGHUP. Everything else is set up
+// once at startup and needs a restart.
+var reloadableSettings = map[string]bool{
+	"limiter-rps":          true,
+	"limiter-burst":        true,
+	"limiter-enabled":      true,
+	"cors-trusted-origins": true,
+	"log-level":            true,
+	"yt-max-results":       true,
+	"sp-max-results":       true,
+}
+
+// reloadConfig reads the configuration sources again and swaps in the reloadable settings. Changes to
+// other settings are logged and ignored, and nothing changes when the new configuration is invalid.
+func (app *application) reloadConfig() {
+	current := app.current.Load()
+
+	next, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		app.logger.Error("config reload failed, keeping the current config", "error", err)
+		return
+	}
+
+	var rejected []string
+	var changes []any // setting, "old -> new" pairs
+	var setErr error
+
+	next.flags.VisitAll(func(f *flag.Flag) {
+		if cliOnlySettings[f.Name] {
+			return
+		}
+
+		old := current.flags.Lookup(f.Name).Value.String()
+		if f.Value.String() == old {
+			return
+		}
+
+		if reloadableSettings[f.Name] {
+			changes = append(changes, f.Name, old+" -> "+f.Value.String())
+			return
+		}
+
+		rejected = append(rejected, f.Name)
+		if err := next.flags.Set(f.Name, old); err != nil && setErr == nil {
+			setErr = err
+		}
+	})
+	if setErr != nil {
+		app.logger.Error("config reload failed, keeping the current config", "error", setErr)
+		return
+	}
+
+	if len(rejected) > 0 {
+		app.logger.Warn("config settings can't be reloaded, restart to apply them", "settings", rejected)
+	}
+
+	if len(changes) == 0 {
+		app.logger.Info("config reloaded without changes")
+		return
+	}
+
+	err = app.logLevel.UnmarshalText([]byte(next.log.level))
+	if err != nil {
+		app.logger.Error("config reload failed, keeping the current config", "error", err)
+		return
+	}
+
+	app.current.Store(next)
+	app.logger.Info("config reloaded", changes...)
+}
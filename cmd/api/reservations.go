@@ -46,7 +46,7 @@ func (app *application) createReservationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err = app.models.Reservations.Insert(reservation)
+	err = app.models.Reservations.Insert(r.Context(), reservation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -59,7 +59,7 @@ func (app *application) createReservationHandler(w http.ResponseWriter, r *http.
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-	app.logger.Info(fmt.Sprintf("Reservation created by %s", user.Username))
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("Reservation created by %s", user.Username))
 }
 
 func (app *application) showReservationHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,7 +69,7 @@ func (app *application) showReservationHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	reservation, err := app.models.Reservations.Get(id)
+	reservation, err := app.models.Reservations.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -86,6 +86,124 @@ func (app *application) showReservationHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
+func (app *application) updateReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	reservation, err := app.models.Reservations.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.authorize(w, r, reservationPolicy, reservation.ID, reservation.UserID) {
+		return
+	}
+
+	var input struct {
+		Title               *string    `json:"title"`
+		Description         *string    `json:"description"`
+		StartTime           *time.Time `json:"start_time"`
+		EndTime             *time.Time `json:"end_time"`
+		Color               *string    `json:"color"`
+		ParentReservationID *int       `json:"parent_reservation_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		reservation.Title = *input.Title
+	}
+	if input.Description != nil {
+		reservation.Description = input.Description
+	}
+	if input.StartTime != nil {
+		reservation.StartTime = *input.StartTime
+	}
+	if input.EndTime != nil {
+		reservation.EndTime = *input.EndTime
+	}
+	if input.Color != nil {
+		reservation.Color = input.Color
+	}
+	if input.ParentReservationID != nil {
+		reservation.ParentReservationID = *input.ParentReservationID
+	}
+
+	v := validator.New()
+	if data.ValidateReservation(v, reservation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reservations.Update(r.Context(), reservation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reservation": reservation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	reservation, err := app.models.Reservations.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.authorize(w, r, reservationPolicy, reservation.ID, reservation.UserID) {
+		return
+	}
+
+	err = app.models.Reservations.Delete(r.Context(), reservation.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "reservation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) listReservationsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		CreatedBy string
@@ -107,7 +225,7 @@ func (app *application) listReservationsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	reservations, metadata, err := app.models.Reservations.GetAll(input.CreatedBy, input.Filters)
+	reservations, metadata, err := app.models.Reservations.GetAll(r.Context(), input.CreatedBy, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
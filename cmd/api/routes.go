@@ -5,15 +5,26 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	return otelhttp.NewHandler(app.logRequest(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.router())))))), "http.server")
+}
+
+// router returns the routes without the middleware in front of them.
+func (app *application) router() http.Handler {
+	router := patternRouter{Router: httprouter.New(), app: app}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/recommendations", app.listRecommendationsHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/recommendations", app.requirePermission("recommendations:write", app.createRecommendationHandler))
@@ -22,20 +33,101 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodDelete, "/v1/recommendations/:id", app.requirePermission("recommendations:write", app.deleteRecommendationHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/comments", app.requirePermission("comments:write", app.createCommentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", app.requirePermission("comments:write", app.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", app.requirePermission("comments:write", app.deleteCommentHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/search", app.requirePermission("recommendations:write", app.searchMusicData))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/users/:username", app.showUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:username", app.currentUserAlias(app.requireAuthenticatedUser(app.showCurrentUserHandler), app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.requireSession(app.enrolTOTPHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requireActivatedUser(app.requireSession(app.confirmTOTPHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireSession(app.disableTOTPHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:username/followers", app.listFollowersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:username/following", app.listFollowingHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/follows/:username", app.requireActivatedUser(app.followUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/follows/:username", app.requireActivatedUser(app.unfollowUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/feed", app.requirePermission("recommendations:read", app.showFeedHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:manage", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:manage", app.showAdminUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:manage", app.deleteUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:manage", app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:manage", app.grantPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:manage", app.revokePermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:manage", app.logoutUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:manage", app.assignRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:code", app.requirePermission("users:manage", app.unassignRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:manage", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:manage", app.listRolesHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/reservations", app.requirePermission("reservations:read", app.listReservationsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/reservations", app.requirePermission("reservations:write", app.createReservationHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/reservations/:id", app.requirePermission("reservations:read", app.showReservationHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reservations/:id", app.requirePermission("reservations:write", app.updateReservationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reservations/:id", app.requirePermission("reservations:write", app.deleteReservationHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/authentication", app.requireSession(app.listSessionsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/:id", app.requireSession(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.createOIDCAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/authorize", app.oidcAuthorizeHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.requireSession(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.requireSession(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.requireSession(app.deleteAPIKeyHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("metrics:view", expvar.Handler().ServeHTTP))
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/*item", app.requirePermission("metrics:view", app.pprofHandler))
+	router.HandlerFunc(http.MethodPost, "/debug/pprof/*item", app.requirePermission("metrics:view", app.pprofHandler))
+	router.HandlerFunc(http.MethodGet, "/metrics", app.requirePermission("metrics:view", promhttp.Handler().ServeHTTP))
+
+	return router
+}
+
+// currentUserAlias serves GET /v1/users/me, which httprouter cannot register next to /v1/users/:username.
+// "me" never clashes with a real username because ValidateUsername requires at least 3 bytes.
+func (app *application) currentUserAlias(me, other http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := app.readUsernameParam(r)
+		if username == "me" {
+			me(w, r)
+			return
+		}
+		other(w, r)
+	}
+}
+
+// patternRouter records the pattern of the matched route in the request info and on the request span,
+// which httprouter itself doesn't expose. Metrics and spans are named by pattern rather than path to
+// keep their cardinality bounded.
+type patternRouter struct {
+	*httprouter.Router
+	app *application
+}
+
+func (pr patternRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	pr.Handler(method, path, handler)
+}
+
+func (pr patternRouter) Handler(method, path string, handler http.Handler) {
+	pr.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := pr.app.contextGetRequestInfo(r); info != nil {
+			info.route = path
+		}
 
-	router.Handler(http.MethodGet, "/debug/vars/", expvar.Handler())
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + path)
+		span.SetAttributes(attribute.String("http.route", path))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+		handler.ServeHTTP(w, r)
+	}))
 }
@@ -55,14 +55,16 @@ func (app *application) searchMusicData(w http.ResponseWriter, r *http.Request)
 
 	var results []SearchResult
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	ytResults, err := app.youtube.SearchMusic(ctx, input.Query, app.config.yt.maxResults)
+	start := time.Now()
+	ytResults, err := app.youtube.SearchMusic(ctx, input.Query, app.current.Load().yt.maxResults)
+	searchDuration.WithLabelValues(string(SourceYoutube), resultLabel(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
 		switch {
 		case strings.HasPrefix(err.Error(), "youtube search call failed"):
-			app.logger.Error(err.Error())
+			app.logger.ErrorContext(r.Context(), err.Error())
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -73,14 +75,16 @@ func (app *application) searchMusicData(w http.ResponseWriter, r *http.Request)
 		results = append(results, fromYoutubeResult(youtubeResult))
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel = context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	spResults, err := app.spotify.SearchMusic(ctx, input.Query, app.config.sp.maxResults)
+	start = time.Now()
+	spResults, err := app.spotify.SearchMusic(ctx, input.Query, app.current.Load().sp.maxResults)
+	searchDuration.WithLabelValues(string(SourceSpotify), resultLabel(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
 		switch {
 		case strings.HasPrefix(err.Error(), "spotify search call failed"):
-			app.logger.Error(err.Error())
+			app.logger.ErrorContext(r.Context(), err.Error())
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
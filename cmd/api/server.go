@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,17 +14,58 @@ import (
 )
 
 func (app *application) serve() error {
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	srv := app.httpServer(fmt.Sprintf(":%d", app.config.port), app.routes())
+
+	tlsConfig, acmeHandler, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	var redirect *http.Server
+	if tlsConfig != nil {
+		srv.TLSConfig = tlsConfig
+
+		// HTTP/2 is only ever negotiated over TLS
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+
+		if app.config.tls.redirectPort != 0 {
+			redirect = app.redirectServer(acmeHandler)
+
+			// listen before serving, so a taken port fails the startup instead of a background goroutine
+			ln, err := net.Listen("tcp", redirect.Addr)
+			if err != nil {
+				return err
+			}
+
+			app.logger.Info("starting https redirect server", "addr", redirect.Addr)
+			go redirect.Serve(ln)
+		}
 	}
 
+	ln, err := app.listen()
+	if err != nil {
+		return err
+	}
+	srv.Addr = ln.Addr().String()
+
 	shutdownError := make(chan error)
 
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	app.runMaintenance(maintenanceCtx)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for range hup {
+			app.logger.Info("reloading config", "signal", syscall.SIGHUP.String())
+			app.reloadConfig()
+		}
+	}()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -31,23 +73,46 @@ func (app *application) serve() error {
 
 		app.logger.Info("shutting down server", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// fail readiness checks first, and give load balancers a moment to notice before connections get refused
+		app.shuttingDown.Store(true)
+		srv.SetKeepAlivesEnabled(false)
+		if app.config.server.drainDelay > 0 {
+			app.logger.Info("draining server", "delay", app.config.server.drainDelay)
+			time.Sleep(app.config.server.drainDelay)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
+		if redirect != nil {
+			err := redirect.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
 		}
 
+		shutdownErr := srv.Shutdown(ctx)
+
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
-		app.wg.Wait()
-		shutdownError <- nil
+		stopMaintenance()
+		app.waitBackground(app.config.server.backgroundTimeout)
+
+		_, err := app.models.Tokens.LastUsed.Flush(context.Background())
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		shutdownError <- shutdownErr
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "tls", tlsConfig != nil)
 
-	err := srv.ListenAndServe()
+	if tlsConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -60,3 +125,33 @@ func (app *application) serve() error {
 	app.logger.Info("stopped server", "addr", srv.Addr)
 	return nil
 }
+
+// httpServer returns a server with the configured timeouts and limits.
+func (app *application) httpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+}
+
+// waitBackground waits for background tasks, like emails being sent, but gives up after timeout so a
+// hanging task can't keep the process from exiting.
+func (app *application) waitBackground(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		app.logger.Warn("background tasks still running after timeout, exiting anyway", "timeout", timeout)
+	}
+}
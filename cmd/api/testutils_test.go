@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+	"github.com/julienschmidt/httprouter"
+)
+
+// newTestDB opens the database given by UKROP_TEST_DB_DSN and migrates a schema created just for the
+// test, which is dropped again once it finishes. Tests using it are skipped when the variable isn't set.
+// The database needs the citext extension installed.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("UKROP_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("UKROP_TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single connection, so the search path set below applies to every query
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	_, err = db.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	_, err = db.Exec("SET search_path TO " + schema + ", public")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+
+	for _, migration := range migrations {
+		query, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(query))
+		if err != nil {
+			t.Fatalf("%s: %s", filepath.Base(migration), err)
+		}
+	}
+
+	return db
+}
+
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewModels(newTestDB(t)),
+	}
+}
+
+func insertTestUser(t *testing.T, app *application, username string, activated bool) *data.User {
+	t.Helper()
+
+	user := &data.User{
+		Name:      username,
+		Username:  username,
+		Email:     username + "@example.com",
+		Activated: activated,
+	}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// serveAs calls the handler the way the router would for a request to a resource with the given id, made
+// by user holding permissions, skipping the middleware in front of it.
+func serveAs(app *application, handler http.HandlerFunc, user *data.User, permissions data.Permissions, method string, id int, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+
+	params := httprouter.Params{{Key: "id", Value: strconv.Itoa(id)}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, permissions)
+
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+
+	return rr
+}
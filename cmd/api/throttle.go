@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottle counts failed logins per key (account or IP) and locks a key out with exponential
+// backoff once it reaches maxAttempts failures in a row.
+type loginThrottle struct {
+	mu          sync.Mutex
+	maxAttempts int
+	lockout     time.Duration
+	maxLockout  time.Duration
+	attempts    map[string]*loginAttempts
+}
+
+func newLoginThrottle(maxAttempts int, lockout, maxLockout time.Duration) *loginThrottle {
+	t := &loginThrottle{
+		maxAttempts: maxAttempts,
+		lockout:     lockout,
+		maxLockout:  maxLockout,
+		attempts:    make(map[string]*loginAttempts),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			t.mu.Lock()
+
+			for key, a := range t.attempts {
+				if time.Now().After(a.lockedUntil) && time.Since(a.lastFailure) > t.maxLockout {
+					delete(t.attempts, key)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+func accountThrottleKey(login string) string {
+	return "account:" + strings.ToLower(login)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// retryAfter returns how long the first locked out key still has to wait, or zero if none is locked.
+func (t *loginThrottle) retryAfter(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if a, found := t.attempts[key]; found {
+			wait = max(wait, time.Until(a.lockedUntil))
+		}
+	}
+	return wait
+}
+
+// fail records a failed login for the key and reports whether the key got locked out by it.
+func (t *loginThrottle) fail(key string) (locked bool, lockout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, found := t.attempts[key]
+	if !found {
+		a = &loginAttempts{}
+		t.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = time.Now()
+
+	if a.failures < t.maxAttempts {
+		return false, 0
+	}
+
+	lockout = t.lockout << min(a.failures-t.maxAttempts, 30) // 1x, 2x, 4x... the base lockout
+	if lockout <= 0 || lockout > t.maxLockout {
+		lockout = t.maxLockout
+	}
+	a.lockedUntil = a.lastFailure.Add(lockout)
+
+	return true, lockout
+}
+
+// reset forgets the failures of an account after it signed in. IP keys are left to expire on their own,
+// or logging into an account of one's own would clear the lockout of an IP spraying passwords at others.
+func (t *loginThrottle) reset(accountKey string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.attempts, accountKey)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleResetKeepsIPCounter(t *testing.T) {
+	throttle := newLoginThrottle(3, time.Minute, time.Hour)
+
+	ip := ipThrottleKey("192.0.2.1")
+	victim := accountThrottleKey("victim@example.com")
+	attacker := accountThrottleKey("attacker@example.com")
+
+	// passwords sprayed at another account, with a successful login into the attacker's own in between
+	throttle.fail(victim)
+	throttle.fail(ip)
+	throttle.fail(victim)
+	throttle.fail(ip)
+	throttle.reset(attacker)
+
+	locked, _ := throttle.fail(ip)
+	if !locked {
+		t.Fatal("ip not locked after the third failure")
+	}
+
+	throttle.reset(attacker)
+
+	if throttle.retryAfter(ip) <= 0 {
+		t.Error("signing into another account cleared the ip lockout")
+	}
+	if throttle.retryAfter(attacker) != 0 {
+		t.Error("account is locked out")
+	}
+}
+
+func TestLoginThrottleResetClearsAccount(t *testing.T) {
+	throttle := newLoginThrottle(1, time.Minute, time.Hour)
+	account := accountThrottleKey("user@example.com")
+
+	throttle.fail(account)
+	if throttle.retryAfter(account) <= 0 {
+		t.Fatal("account not locked")
+	}
+
+	throttle.reset(account)
+	if throttle.retryAfter(account) != 0 {
+		t.Error("account still locked after reset")
+	}
+}
+
+// TestLoginKeepsIPCounter checks that signing into one's own account between guesses at others doesn't
+// lift the lockout of the IP they all come from.
+func TestLoginKeepsIPCounter(t *testing.T) {
+	app := newTestApplication(t)
+	app.logins = newLoginThrottle(3, time.Minute, time.Hour)
+
+	own := insertTestUser(t, app, "attacker", true)
+
+	login := func(login, password string) int {
+		body := fmt.Sprintf(`{"login": %q, "password": %q}`, login, password)
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:4321"
+
+		rr := httptest.NewRecorder()
+		app.createAuthenticationTokenHandler(rr, r)
+		return rr.Code
+	}
+
+	steps := []struct {
+		login    string
+		password string
+		want     int
+	}{
+		{"victim1@example.com", "guess12345678", http.StatusUnauthorized},
+		{"victim2@example.com", "guess12345678", http.StatusUnauthorized},
+		{own.Email, "pa55word1234", http.StatusCreated},
+		{"victim3@example.com", "guess12345678", http.StatusUnauthorized},
+		{"victim4@example.com", "guess12345678", http.StatusTooManyRequests},
+	}
+
+	for i, step := range steps {
+		if got := login(step.login, step.password); got != step.want {
+			t.Fatalf("step %d: status = %d, want %d", i, got, step.want)
+		}
+	}
+}
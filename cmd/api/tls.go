@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes, at most once per
+// handshake.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate from a pair of files and loads it again once either file changes,
+// so renewed certificates get picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.checked) >= certCheckInterval {
+		cr.checked = time.Now()
+
+		// keep serving the old certificate, the files may be halfway through being replaced
+		err := cr.reload()
+		if err != nil {
+			cr.logger.Error("failed to reload tls certificate", "cert", cr.certFile, "error", err)
+		}
+	}
+
+	return cr.cert, nil
+}
+
+// reload loads the certificate when the files were modified since it was last loaded.
+func (cr *certReloader) reload() error {
+	var modTime time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	if !modTime.After(cr.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if cr.cert != nil {
+		cr.logger.Info("reloaded tls certificate", "cert", cr.certFile)
+	}
+
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the server, with certificates either read from files or
+// obtained through ACME, and the handler answering ACME challenges on the redirect listener. Both are nil
+// when TLS is disabled.
+func (app *application) tlsConfig() (*tls.Config, func(http.Handler) http.Handler, error) {
+	cfg := app.config.tls
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// only used by TLS 1.2, TLS 1.3 suites aren't configurable and are all fine
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	switch {
+	case cfg.certFile != "":
+		certs, err := newCertReloader(cfg.certFile, cfg.keyFile, app.logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+
+		tlsConfig.GetCertificate = certs.GetCertificate
+		return tlsConfig, nil, nil
+	case len(cfg.acmeDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.acmeDomains...),
+			Cache:      autocert.DirCache(cfg.acmeCacheDir),
+			Email:      cfg.acmeEmail,
+		}
+
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		return tlsConfig, manager.HTTPHandler, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the TLS listener.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+
+	// only redirect idempotent requests, anything else would lose its body or be silently turned into a GET
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		app.errorResponse(w, r, http.StatusBadRequest, "use HTTPS: "+target)
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// redirectServer returns the plain HTTP server redirecting to the TLS listener, which also answers ACME
+// HTTP challenges when certificates are obtained through ACME.
+func (app *application) redirectServer(acmeHandler func(http.Handler) http.Handler) *http.Server {
+	var handler http.Handler = http.HandlerFunc(app.redirectToHTTPS)
+	if acmeHandler != nil {
+		handler = acmeHandler(handler)
+	}
+
+	return app.httpServer(fmt.Sprintf(":%d", app.config.tls.redirectPort), handler)
+}
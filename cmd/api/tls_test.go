@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for localhost with the given serial number and returns
+// it parsed, for the client to trust.
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func newTLSTestApplication(t *testing.T) (*application, *x509.Certificate) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.tls.certFile = filepath.Join(dir, "cert.pem")
+	app.config.tls.keyFile = filepath.Join(dir, "key.pem")
+
+	cert := writeTestCert(t, app.config.tls.certFile, app.config.tls.keyFile, 1)
+
+	return app, cert
+}
+
+func TestCertReloader(t *testing.T) {
+	app, _ := newTLSTestApplication(t)
+
+	certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile, app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func() int64 {
+		cert, err := certs.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 1 {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	writeTestCert(t, app.config.tls.certFile, app.config.tls.keyFile, 2)
+
+	// make the rewrite visible to a file system with coarse modification times
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{app.config.tls.certFile, app.config.tls.keyFile} {
+		err = os.Chtimes(file, later, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := serial(); got != 1 {
+		t.Errorf("serial = %d before the check interval passed, want 1", got)
+	}
+
+	certs.mu.Lock()
+	certs.checked = time.Time{}
+	certs.mu.Unlock()
+
+	if got := serial(); got != 2 {
+		t.Errorf("serial = %d after the reload, want 2", got)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	app, cert := newTLSTestApplication(t)
+
+	tlsConfig, acmeHandler, err := app.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case tlsConfig.MinVersion != tls.VersionTLS12:
+		t.Errorf("MinVersion = %x, want TLS 1.2", tlsConfig.MinVersion)
+	case !slices.Contains(tlsConfig.NextProtos, "h2"):
+		t.Errorf("NextProtos = %v, want h2", tlsConfig.NextProtos)
+	case acmeHandler != nil:
+		t.Error("ACME handler returned for certificate files")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := app.httpServer(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLSConfig = tlsConfig
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+	}}
+
+	res, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.ProtoMajor != 2 {
+		t.Errorf("protocol = %s, want HTTP/2", res.Proto)
+	}
+
+	// anything older than TLS 1.2 is turned away
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}}}
+
+	_, err = client.Get("https://" + ln.Addr().String() + "/")
+	if err == nil {
+		t.Error("TLS 1.1 handshake succeeded")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name       string
+		port       int
+		method     string
+		target     string
+		wantStatus int
+		wantURL    string
+	}{
+		{"default port", 443, http.MethodGet, "http://example.com/v1/recommendations?page=2", http.StatusMovedPermanently, "https://example.com/v1/recommendations?page=2"},
+		{"other port", 4000, http.MethodGet, "http://example.com:8080/v1/healthcheck", http.StatusMovedPermanently, "https://example.com:4000/v1/healthcheck"},
+		{"head", 443, http.MethodHead, "http://example.com/", http.StatusMovedPermanently, "https://example.com/"},
+		{"post", 443, http.MethodPost, "http://example.com/v1/tokens/authentication", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			app.config.port = tt.port
+
+			rr := httptest.NewRecorder()
+			app.redirectServer(nil).Handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantURL {
+				t.Errorf("Location = %q, want %q", got, tt.wantURL)
+			}
+		})
+	}
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/jwt"
 	"api.ukrop.pl/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
+		Login    string `json:"login"`
+		Email    string `json:"email"` // deprecated, kept for clients predating login
 		Password string `json:"password"`
 	}
 
@@ -21,9 +27,13 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	if input.Login == "" {
+		input.Login = input.Email
+	}
+
 	v := validator.New()
 
-	data.ValidateEmail(v, input.Email)
+	data.ValidateLogin(v, input.Login)
 	data.ValidatePasswordPlaintext(v, input.Password)
 
 	if !v.Valid() {
@@ -31,10 +41,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	ip := realip.FromRequest(r)
+	keys := []string{accountThrottleKey(input.Login), ipThrottleKey(ip)}
+
+	if retryAfter := app.logins.retryAfter(keys...); retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	user, err := app.models.Users.GetByLogin(r.Context(), input.Login)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			data.SimulatePasswordMatch(input.Password)
+			app.loginFailed(r.Context(), nil, ip, keys...)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -42,6 +62,14 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// count failures against the account whether it was named by email or username
+	keys[0] = accountThrottleKey(user.Email)
+
+	if retryAfter := app.logins.retryAfter(keys[0]); retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -49,23 +77,239 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.loginFailed(r.Context(), user, ip, keys...)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if app.challengeSecondFactor(w, r, user) {
+		return
+	}
+
+	app.logins.reset(keys[0])
+	app.writeAuthenticationTokens(w, r, user)
+}
+
+// challengeSecondFactor responds with a short-lived token to be exchanged together with a 2FA code
+// when the user has 2FA enabled. It reports whether a response was written.
+func (app *application) challengeSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	totp, err := app.models.TOTP.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if totp == nil || !totp.Enabled {
+		return false
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 5*time.Minute, data.ScopeTwoFactor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"two_factor_token": token, "message": "exchange this token together with a 2FA code at /v1/tokens/two-factor"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
+
+// writeAuthenticationTokens completes a login by responding with fresh tokens for the user.
+func (app *application) writeAuthenticationTokens(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	env, err := app.issueAuthenticationTokens(r, user, permissions, "")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user, "permissions": permissions}, nil)
+	env["user"] = user
+	env["permissions"] = permissions
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loginFailed records a failed login for every key and lets the account owner know once their
+// account gets locked out. user is nil when nobody is registered with the given email.
+func (app *application) loginFailed(ctx context.Context, user *data.User, ip string, keys ...string) {
+	for _, key := range keys {
+		locked, lockout := app.logins.fail(key)
+		if !locked || user == nil || key != accountThrottleKey(user.Email) {
+			continue
+		}
+
+		app.logger.WarnContext(ctx, fmt.Sprintf("locked out logins for user %s for %s after failed attempt from %s", user.Username, lockout, ip))
+
+		app.background(func() {
+			lockoutData := map[string]any{
+				"username": user.Username,
+				"ip":       ip,
+				"lockout":  lockout.String(),
+			}
+			err := app.mailer.Send(ctx, user.Email, "login_lockout.tmpl", lockoutData)
+			app.logger.InfoContext(ctx, fmt.Sprintf("sending login lockout notification to user %s", user.Username))
+			if err != nil {
+				app.logger.ErrorContext(ctx, err.Error())
+			}
+		})
+	}
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.jwt.enabled() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.UseRefresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.WarnContext(r.Context(), "refresh token reused, revoked its token family")
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.issueAuthenticationTokens(r, user, permissions, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueAuthenticationTokens returns an opaque authentication token, or a signed access token paired with a
+// refresh token when stateless tokens are enabled. family continues an existing refresh token family.
+func (app *application) issueAuthenticationTokens(r *http.Request, user *data.User, permissions data.Permissions, family string) (envelope, error) {
+	userAgent, ip := r.UserAgent(), realip.FromRequest(r)
+
+	if !app.config.jwt.enabled() {
+		token, err := app.models.Tokens.NewSession(r.Context(), user.ID, 24*time.Hour, userAgent, ip)
+		if err != nil {
+			return nil, err
+		}
+
+		return envelope{"authentication_token": token}, nil
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(r.Context(), user.ID, app.config.jwt.refreshTTL, family, userAgent, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.jwt.accessTTL)
+
+	claims := jwt.Claims{
+		Issuer:      app.config.jwt.issuer,
+		Subject:     strconv.Itoa(user.ID),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		Name:        user.Name,
+		Username:    user.Username,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		SessionID:   refreshToken.Family,
+	}
+
+	accessToken, err := jwt.Sign(claims, []byte(app.config.jwt.secret))
+	if err != nil {
+		return nil, err
+	}
+
+	env := envelope{
+		"authentication_token": &data.Token{Plaintext: accessToken, Expiry: expiry},
+		"refresh_token":        refreshToken,
+	}
+
+	return env, nil
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(r.Context(), app.contextGetUser(r).ID, app.contextGetSession(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(r.Context(), app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+)
+
+func TestUseRefreshReuseRevokesFamily(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "user", true)
+
+	first, err := app.models.Tokens.NewRefresh(ctx, user.ID, time.Hour, "", "test", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a session on another device, which must survive the revocation
+	other, err := app.models.Tokens.NewRefresh(ctx, user.ID, time.Hour, "", "test", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, err := app.models.Tokens.UseRefresh(ctx, first.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used.Family != first.Family || used.UserID != user.ID {
+		t.Fatalf("used token = %+v, want family %s of user %d", used, first.Family, user.ID)
+	}
+
+	rotated, err := app.models.Tokens.NewRefresh(ctx, user.ID, time.Hour, used.Family, "test", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.models.Tokens.UseRefresh(ctx, first.Plaintext)
+	if !errors.Is(err, data.ErrTokenReused) {
+		t.Fatalf("reusing the rotated token: err = %v, want %v", err, data.ErrTokenReused)
+	}
+
+	_, err = app.models.Tokens.UseRefresh(ctx, rotated.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("token rotated into the revoked family: err = %v, want %v", err, data.ErrRecordNotFound)
+	}
+
+	_, err = app.models.Tokens.UseRefresh(ctx, other.Plaintext)
+	if err != nil {
+		t.Errorf("token of another family: %s", err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/totp"
+	"api.ukrop.pl/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// totpIssuer is the service name authenticator apps show next to the username.
+const totpIssuer = "Ukrop"
+
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	secret := totp.GenerateSecret()
+
+	err := app.models.TOTP.Enrol(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": envelope{
+			"secret": secret,
+			"uri":    totp.URI(totpIssuer, user.Username, secret),
+		},
+		"message": "add the secret to your authenticator app and confirm it with a code",
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	settings, err := app.models.TOTP.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("two-factor authentication enrolment has not been started"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if settings.Enabled {
+		app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	counter, ok := totp.Validate(input.Code, settings.Secret, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes := data.NewRecoveryCodes()
+
+	err = app.models.TOTP.Enable(r.Context(), user.ID, counter, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s enabled two-factor authentication", user.Username))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes, "message": "store the recovery codes somewhere safe, each of them works only once"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Disable(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), fmt.Sprintf("user %s disabled two-factor authentication", user.Username))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTwoFactorAuthenticationTokenHandler exchanges the token from a password login together with
+// a TOTP or recovery code for regular authentication tokens.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check((input.Code == "") != (input.RecoveryCode == ""), "code", "exactly one of code or recovery_code must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ip := realip.FromRequest(r)
+	keys := []string{accountThrottleKey(user.Email), ipThrottleKey(ip)}
+
+	if retryAfter := app.logins.retryAfter(keys...); retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	var ok bool
+
+	if input.RecoveryCode != "" {
+		ok, err = app.models.TOTP.UseRecoveryCode(r.Context(), user.ID, input.RecoveryCode)
+	} else {
+		ok, err = app.useTOTPCode(r.Context(), user.ID, input.Code)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.loginFailed(r.Context(), user, ip, keys...)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logins.reset(keys[0])
+	app.writeAuthenticationTokens(w, r, user)
+}
+
+// useTOTPCode checks the code against the user's enabled secret and marks it as used.
+func (app *application) useTOTPCode(ctx context.Context, userID int, code string) (bool, error) {
+	settings, err := app.models.TOTP.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	counter, ok := totp.Validate(code, settings.Secret, time.Now())
+	if !ok || !settings.Enabled {
+		return false, nil
+	}
+
+	return app.models.TOTP.UseCounter(ctx, userID, counter)
+}
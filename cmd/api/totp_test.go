@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api.ukrop.pl/internal/data"
+	"api.ukrop.pl/internal/totp"
+)
+
+// enableTestTOTP turns on 2FA for the user and returns the secret and the recovery codes.
+func enableTestTOTP(t *testing.T, app *application, user *data.User) (string, []string) {
+	t.Helper()
+
+	ctx := context.Background()
+	secret := totp.GenerateSecret()
+	recoveryCodes := data.NewRecoveryCodes()
+
+	err := app.models.TOTP.Enrol(ctx, user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.TOTP.Enable(ctx, user.ID, 0, recoveryCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secret, recoveryCodes
+}
+
+func TestTOTPModelSingleUse(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "user", true)
+	other := insertTestUser(t, app, "other", true)
+	_, recoveryCodes := enableTestTOTP(t, app, user)
+
+	counter := time.Now().Unix() / int64(totp.Period.Seconds())
+
+	steps := []struct {
+		counter int64
+		want    bool
+	}{
+		{counter, true},
+		{counter, false},     // replayed
+		{counter - 1, false}, // an older code still inside the window
+		{counter + 1, true},
+	}
+
+	for i, step := range steps {
+		ok, err := app.models.TOTP.UseCounter(ctx, user.ID, step.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != step.want {
+			t.Errorf("step %d: UseCounter(%d) = %t, want %t", i, step.counter, ok, step.want)
+		}
+	}
+
+	ok, err := app.models.TOTP.UseRecoveryCode(ctx, other.ID, recoveryCodes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("recovery code accepted for another user")
+	}
+
+	for i, want := range []bool{true, false} {
+		ok, err := app.models.TOTP.UseRecoveryCode(ctx, user.ID, strings.ToUpper(recoveryCodes[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("use %d: UseRecoveryCode = %t, want %t", i+1, ok, want)
+		}
+	}
+}
+
+func TestTwoFactorLoginSingleUse(t *testing.T) {
+	app := newTestApplication(t)
+	app.logins = newLoginThrottle(10, time.Minute, time.Hour)
+
+	user := insertTestUser(t, app, "user", true)
+	secret, recoveryCodes := enableTestTOTP(t, app, user)
+
+	// every attempt starts from a fresh password login, a successful one revokes the outstanding tokens
+	exchange := func(field, code string) int {
+		token, err := app.models.Tokens.New(context.Background(), user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := fmt.Sprintf(`{"token": %q, %q: %q}`, token.Plaintext, field, code)
+
+		rr := httptest.NewRecorder()
+		app.createTwoFactorAuthenticationTokenHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/two-factor", strings.NewReader(body)))
+		return rr.Code
+	}
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name  string
+		field string
+		code  string
+		want  int
+	}{
+		{"code", "code", code, http.StatusCreated},
+		{"replayed code", "code", code, http.StatusUnauthorized},
+		{"recovery code", "recovery_code", recoveryCodes[0], http.StatusCreated},
+		{"reused recovery code", "recovery_code", recoveryCodes[0], http.StatusUnauthorized},
+		{"another recovery code", "recovery_code", recoveryCodes[1], http.StatusCreated},
+	}
+
+	for _, step := range steps {
+		if got := exchange(step.field, step.code); got != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
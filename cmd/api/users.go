@@ -43,7 +43,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -58,19 +58,15 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "recommendations:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	err = app.models.Permissions.AddForUser(user.ID, "comments:write")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	if app.config.users.defaultRole != "" {
+		err = app.models.Roles.AddForUser(r.Context(), user.ID, app.config.users.defaultRole)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -81,10 +77,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
 		}
-		err := app.mailer.Send(user.Email, "user_welcome.tmpl", activationData)
-		app.logger.Info(fmt.Sprintf("sending activation mail to user %s", user.Email))
+		err := app.mailer.Send(r.Context(), user.Email, "user_welcome.tmpl", activationData)
+		app.logger.InfoContext(r.Context(), fmt.Sprintf("sending activation mail to user %s", user.Email))
 		if err != nil {
-			app.logger.Error(err.Error())
+			app.logger.ErrorContext(r.Context(), err.Error())
 		}
 	})
 
@@ -101,7 +97,7 @@ func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := app.models.Users.GetByUsername(username)
+	user, err := app.models.Users.GetByUsername(r.Context(), username)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -112,7 +108,50 @@ func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	// private recommendations only count towards the profile for the owner and for users who can see them in the list
+	caller := app.contextGetUser(r)
+	includePrivate := !caller.IsAnonymous() && (caller.ID == user.ID || app.contextGetPermissions(r).Include("recommendations:write"))
+
+	stats, err := app.models.Profiles.GetStats(r.Context(), user.ID, includePrivate, 5)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     5,
+		Sort:         "-created_at",
+		SortSafelist: []string{"-created_at"},
+	}
+
+	latest, _, err := app.models.Recommendations.GetAll(r.Context(), time.Time{}, user.Username, "", includePrivate, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	followers, err := app.models.Follows.GetFollowers(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	following, err := app.models.Follows.GetFollowing(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user":                   user,
+		"stats":                  stats,
+		"latest_recommendations": latest,
+		"followers":              followers,
+		"following":              following,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -136,7 +175,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -150,7 +189,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -161,7 +200,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"api.ukrop.pl/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix tells API keys apart from authentication tokens in the Authorization header.
+const APIKeyPrefix = "ukrop_"
+
+type APIKey struct {
+	ID          int         `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitzero"` // only set right after the key is created
+	Hash        []byte      `json:"-"`
+	UserID      int         `json:"-"`
+	Permissions Permissions `json:"permissions"` // nil means all of the owner's permissions
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+func NewAPIKey(userID int, name string, permissions Permissions, expiry *time.Time) *APIKey {
+	key := &APIKey{
+		Name:        name,
+		Plaintext:   APIKeyPrefix + rand.Text(),
+		UserID:      userID,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key
+}
+
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(IsAPIKey(plaintext), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == len(APIKeyPrefix)+26, "key", "must be 32 bytes long")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 128, "name", "must not be more than 128 bytes long")
+
+	if key.Permissions != nil {
+		v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB       *DB
+	LastUsed *LastUsedBatcher // optional, nil stops recording when keys were last used
+}
+
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	var permissions any = nil
+	if key.Permissions != nil {
+		permissions = pq.Array([]string(key.Permissions))
+	}
+
+	args := []any{key.UserID, key.Name, key.Hash, permissions, key.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, name, permissions, expiry, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key := APIKey{UserID: userID}
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.Name,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m APIKeyModel) Delete(ctx context.Context, id, userID int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetUserForKey returns the owner of an unexpired key together with the permissions the key grants, which
+// are the owner's effective permissions narrowed down to the key's subset.
+func (m APIKeyModel) GetUserForKey(ctx context.Context, plaintext string) (*User, Permissions, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.username, users.email, users.password_hash, users.activated, users.bio, users.avatar_url, users.version,
+		       ARRAY(` + effectivePermissions + `), api_keys.permissions
+		FROM users
+		INNER JOIN api_keys ON api_keys.user_id = users.id
+		WHERE api_keys.hash = $1 AND (api_keys.expiry IS NULL OR api_keys.expiry > NOW())`
+
+	var user User
+	var permissions, keyPermissions Permissions
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.Version,
+		pq.Array((*[]string)(&permissions)),
+		pq.Array((*[]string)(&keyPermissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if keyPermissions != nil {
+		permissions = permissions.Intersect(keyPermissions)
+	}
+
+	return &user, permissions, nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"api.ukrop.pl/internal/validator"
@@ -26,23 +27,117 @@ func ValidateComment(v *validator.Validator, comment *Comment) {
 }
 
 type CommentModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
-func (m CommentModel) Insert(comment *Comment) error {
+func (m CommentModel) Insert(ctx context.Context, comment *Comment) error {
 	query := `
 		INSERT INTO comments (recommendation_id, user_id, content)
 		VALUES ($1, $2, $3)
 		RETURNING id, created_at, version`
 	args := []any{comment.RecommendationID, comment.UserID, comment.Content}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
 }
 
-func (m CommentModel) GetForRecommendation(recommendationID int) ([]*Comment, error) {
+func (m CommentModel) Get(ctx context.Context, id int) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT c.id, c.created_at, c.recommendation_id, c.user_id, c.content, c.version,
+			   u.id, u.name, u.username
+		FROM comments c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1`
+
+	var comment Comment
+	comment.CreatedBy = &User{}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.RecommendationID,
+		&comment.UserID,
+		&comment.Content,
+		&comment.Version,
+		&comment.CreatedBy.ID,
+		&comment.CreatedBy.Name,
+		&comment.CreatedBy.Username,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+func (m CommentModel) Update(ctx context.Context, comment *Comment) error {
+	query := `
+		UPDATE comments
+		SET content = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	args := []any{comment.Content, comment.ID, comment.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CommentModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM comments
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m CommentModel) GetForRecommendation(ctx context.Context, recommendationID int) ([]*Comment, error) {
 	query := `
 		SELECT c.id, c.created_at, c.user_id, c.content, c.version,
 			   u.id, u.name, u.username
@@ -51,7 +146,7 @@ func (m CommentModel) GetForRecommendation(recommendationID int) ([]*Comment, er
 		WHERE c.recommendation_id = $1
 		ORDER BY c.created_at ASC`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{recommendationID}
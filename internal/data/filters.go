@@ -1,8 +1,12 @@
 package data
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"slices"
 	"strings"
+	"time"
 
 	"api.ukrop.pl/internal/validator"
 )
@@ -67,3 +71,56 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// Cursor points at the last row of a keyset page ordered by (created_at, id) descending.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+func (c Cursor) IsZero() bool {
+	return c.ID == 0
+}
+
+func (c Cursor) Encode() string {
+	if c.IsZero() {
+		return ""
+	}
+	raw := fmt.Sprintf("%d,%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+
+	var nanos int64
+	var id int
+	_, err = fmt.Sscanf(string(raw), "%d,%d", &nanos, &id)
+	if err != nil || id < 1 {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+
+	return Cursor{CreatedAt: time.Unix(0, nanos), ID: id}, nil
+}
+
+type KeysetFilters struct {
+	After    Cursor
+	PageSize int
+}
+
+func ValidateKeysetFilters(v *validator.Validator, f KeysetFilters) {
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+}
+
+type KeysetMetadata struct {
+	PageSize   int    `json:"page_size,omitzero"`
+	NextCursor string `json:"next_cursor,omitzero"`
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateFollow = errors.New("duplicate follow")
+	ErrSelfFollow      = errors.New("self follow")
+)
+
+type FollowModel struct {
+	DB *DB
+}
+
+func (m FollowModel) Insert(ctx context.Context, followerID, followedID int) error {
+	query := `
+		INSERT INTO follows (follower_id, followed_id)
+		VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, followerID, followedID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "follows_pkey"`:
+			return ErrDuplicateFollow
+		case err.Error() == `pq: new row for relation "follows" violates check constraint "follows_self_check"`:
+			return ErrSelfFollow
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m FollowModel) Delete(ctx context.Context, followerID, followedID int) error {
+	query := `
+		DELETE FROM follows
+		WHERE follower_id = $1 AND followed_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, followerID, followedID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m FollowModel) GetFollowers(ctx context.Context, userID int) ([]*User, error) {
+	query := `
+		SELECT u.id, u.name, u.username
+		FROM follows f
+		INNER JOIN users u ON u.id = f.follower_id
+		WHERE f.followed_id = $1
+		ORDER BY f.created_at DESC`
+
+	return m.getUsers(ctx, query, userID)
+}
+
+func (m FollowModel) GetFollowing(ctx context.Context, userID int) ([]*User, error) {
+	query := `
+		SELECT u.id, u.name, u.username
+		FROM follows f
+		INNER JOIN users u ON u.id = f.followed_id
+		WHERE f.follower_id = $1
+		ORDER BY f.created_at DESC`
+
+	return m.getUsers(ctx, query, userID)
+}
+
+func (m FollowModel) getUsers(ctx context.Context, query string, args ...any) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&user.ID, &user.Name, &user.Username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -17,15 +18,37 @@ type Models struct {
 	Users           UserModel
 	Comments        CommentModel
 	Reservations    ReservationModel
+	Follows         FollowModel
+	Profiles        ProfileModel
+	Roles           RoleModel
+	APIKeys         APIKeyModel
+	TOTP            TOTPModel
 }
 
-func NewModels(db *sql.DB) Models {
+func NewModels(sqlDB *sql.DB) Models {
+	db := &DB{DB: sqlDB}
+	lastUsed := NewLastUsedBatcher(db)
+
 	return Models{
 		Recommendations: RecommendationModel{DB: db},
 		Permissions:     PermissionModel{DB: db},
-		Tokens:          TokenModel{DB: db},
+		Tokens:          TokenModel{DB: db, LastUsed: lastUsed},
 		Users:           UserModel{DB: db},
 		Comments:        CommentModel{DB: db},
 		Reservations:    ReservationModel{DB: db},
+		Follows:         FollowModel{DB: db},
+		Profiles:        ProfileModel{DB: db},
+		Roles:           RoleModel{DB: db},
+		APIKeys:         APIKeyModel{DB: db, LastUsed: lastUsed},
+		TOTP:            TOTPModel{DB: db},
 	}
 }
+
+// EnablePermissionCache makes permission lookups go through a TTL cache shared by the permission, role and user
+// models, each of which invalidates it when a change affects someone's permissions.
+func (m *Models) EnablePermissionCache(ttl time.Duration) {
+	cache := NewPermissionCache(ttl)
+	m.Permissions.Cache = cache
+	m.Roles.Cache = cache
+	m.Users.Cache = cache
+}
@@ -2,32 +2,145 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// effectivePermissions selects the permission codes of users.id, granted directly or through one of their roles.
+const effectivePermissions = `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = users.id
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = users.id`
+
 type Permissions []string
 
 func (p Permissions) Include(code string) bool {
 	return slices.Contains(p, code)
 }
 
+// Intersect returns the permissions present in both p and other.
+func (p Permissions) Intersect(other Permissions) Permissions {
+	result := Permissions{}
+	for _, code := range p {
+		if other.Include(code) {
+			result = append(result, code)
+		}
+	}
+	return result
+}
+
+// PermissionCache keeps effective permissions per user for a fixed TTL. It is local to the process,
+// so changes made by another instance only become visible once the entry expires. It serves
+// GetAllForUser, used when issuing tokens and by the admin view; authenticate loads permissions
+// in the same query as the user and doesn't need it.
+type PermissionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[int]permissionCacheEntry
+	sweepAt int
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:     ttl,
+		entries: make(map[int]permissionCacheEntry),
+		sweepAt: 1024,
+	}
+}
+
+func (c *PermissionCache) get(userID int) (Permissions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[userID]
+	if !found {
+		return nil, false
+	}
+	if time.Now().After(entry.expiry) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+func (c *PermissionCache) set(userID int, permissions Permissions) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.sweepAt { // drop expired entries before the map grows any further
+		for id, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, id)
+			}
+		}
+		c.sweepAt = max(1024, 2*len(c.entries))
+	}
+
+	c.entries[userID] = permissionCacheEntry{permissions: permissions, expiry: now.Add(c.ttl)}
+}
+
+func (c *PermissionCache) Invalidate(userID int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
+
+func (c *PermissionCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *DB
+	Cache *PermissionCache // optional, nil disables caching
 }
 
-func (m PermissionModel) GetAllForUser(userID int) (Permissions, error) {
+// GetAllForUser returns the effective permissions of a user, granted either directly or through one of their roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int) (Permissions, error) {
+	if permissions, found := m.Cache.get(userID); found {
+		return permissions, nil
+	}
+
 	query := `
-		SELECT permissions.code
-		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
+		SELECT code FROM users, LATERAL (` + effectivePermissions + `) AS p
 		WHERE users.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -51,17 +164,67 @@ func (m PermissionModel) GetAllForUser(userID int) (Permissions, error) {
 		return nil, err
 	}
 
+	m.Cache.set(userID, permissions)
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.Cache.Invalidate(userID)
+	return err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT permissions.id FROM permissions WHERE permissions.code = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.Cache.Invalidate(userID)
 	return err
 }
+
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT DISTINCT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
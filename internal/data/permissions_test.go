@@ -0,0 +1,55 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPermissionCache(t *testing.T) {
+	cache := NewPermissionCache(time.Hour)
+
+	cache.set(1, Permissions{"recommendations:read"})
+	cache.set(2, Permissions{"comments:read"})
+
+	permissions, found := cache.get(1)
+	if !found || !slices.Equal(permissions, Permissions{"recommendations:read"}) {
+		t.Fatalf("get(1) = %v, %t", permissions, found)
+	}
+
+	cache.Invalidate(1)
+	if _, found := cache.get(1); found {
+		t.Error("invalidated entry still cached")
+	}
+	if _, found := cache.get(2); !found {
+		t.Error("invalidating one user dropped another")
+	}
+
+	cache.InvalidateAll()
+	if _, found := cache.get(2); found {
+		t.Error("entry cached after InvalidateAll")
+	}
+}
+
+func TestPermissionCacheExpiry(t *testing.T) {
+	cache := NewPermissionCache(time.Millisecond)
+
+	cache.set(1, Permissions{"recommendations:read"})
+	time.Sleep(2 * time.Millisecond)
+
+	if _, found := cache.get(1); found {
+		t.Error("expired entry still cached")
+	}
+}
+
+func TestPermissionCacheNil(t *testing.T) {
+	var cache *PermissionCache
+
+	cache.set(1, Permissions{"recommendations:read"})
+	cache.Invalidate(1)
+	cache.InvalidateAll()
+
+	if _, found := cache.get(1); found {
+		t.Error("nil cache returned an entry")
+	}
+}
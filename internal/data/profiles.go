@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type ArtistCount struct {
+	Artist string `json:"artist"`
+	Count  int    `json:"count"`
+}
+
+type ProfileStats struct {
+	Recommendations int            `json:"recommendations"`
+	Comments        int            `json:"comments"`
+	TopArtists      []*ArtistCount `json:"top_artists"`
+}
+
+type ProfileModel struct {
+	DB *DB
+}
+
+// GetStats counts only public recommendations (and comments under them) unless includePrivate is set.
+func (m ProfileModel) GetStats(ctx context.Context, userID int, includePrivate bool, topArtists int) (*ProfileStats, error) {
+	query := `
+		SELECT
+			(SELECT count(*)
+			 FROM recommendations r
+			 WHERE r.user_id = $1 AND ($2 = true OR r.is_public = true)),
+			(SELECT count(*)
+			 FROM comments c
+			 INNER JOIN recommendations r ON r.id = c.recommendation_id
+			 WHERE c.user_id = $1 AND ($2 = true OR r.is_public = true))`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stats := ProfileStats{TopArtists: []*ArtistCount{}}
+
+	err := m.DB.QueryRowContext(ctx, query, userID, includePrivate).Scan(&stats.Recommendations, &stats.Comments)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT min(r.artist), count(*)
+		FROM recommendations r
+		WHERE r.user_id = $1 AND ($2 = true OR r.is_public = true)
+		GROUP BY lower(r.artist)
+		ORDER BY count(*) DESC, min(r.artist) ASC
+		LIMIT $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, includePrivate, topArtists)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var artist ArtistCount
+
+		err := rows.Scan(&artist.Artist, &artist.Count)
+		if err != nil {
+			return nil, err
+		}
+		stats.TopArtists = append(stats.TopArtists, &artist)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
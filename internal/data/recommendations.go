@@ -40,10 +40,10 @@ func ValidateRecommendation(v *validator.Validator, recommendation *Recommendati
 }
 
 type RecommendationModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
-func (m RecommendationModel) Insert(recommendation *Recommendation) error {
+func (m RecommendationModel) Insert(ctx context.Context, recommendation *Recommendation) error {
 	query := `
 		INSERT INTO recommendations (user_id, artist, title, cover_url, yt_link, spotify_link, comment, is_public)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
@@ -53,13 +53,13 @@ func (m RecommendationModel) Insert(recommendation *Recommendation) error {
 		recommendation.YTLink, recommendation.SpotifyLink, recommendation.Comment, recommendation.IsPublic,
 	} // TODO this inserts empty strings "" rather than nulls. Fix it
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&recommendation.ID, &recommendation.CreatedAt, &recommendation.Version)
 }
 
-func (m RecommendationModel) Get(id int) (*Recommendation, error) {
+func (m RecommendationModel) Get(ctx context.Context, id int) (*Recommendation, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -74,7 +74,7 @@ func (m RecommendationModel) Get(id int) (*Recommendation, error) {
 	var recommendation Recommendation
 	recommendation.CreatedBy = &User{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -105,7 +105,7 @@ func (m RecommendationModel) Get(id int) (*Recommendation, error) {
 	return &recommendation, nil
 }
 
-func (m RecommendationModel) Update(recommendation *Recommendation) error {
+func (m RecommendationModel) Update(ctx context.Context, recommendation *Recommendation) error {
 	query := `
         UPDATE recommendations 
         SET artist = $1, title = $2, cover_url = $3, yt_link = $4, spotify_link = $5, comment = $6, is_public = $7, version = version + 1
@@ -124,7 +124,7 @@ func (m RecommendationModel) Update(recommendation *Recommendation) error {
 		recommendation.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&recommendation.Version)
@@ -139,7 +139,7 @@ func (m RecommendationModel) Update(recommendation *Recommendation) error {
 	return nil
 }
 
-func (m RecommendationModel) Delete(id int) error {
+func (m RecommendationModel) Delete(ctx context.Context, id int) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -148,7 +148,7 @@ func (m RecommendationModel) Delete(id int) error {
 		DELETE FROM recommendations
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -168,7 +168,7 @@ func (m RecommendationModel) Delete(id int) error {
 	return nil
 }
 
-func (m RecommendationModel) GetAll(createdAt time.Time, createdBy, title string, privatePermissions bool, filters Filters) ([]*Recommendation, Metadata, error) {
+func (m RecommendationModel) GetAll(ctx context.Context, createdAt time.Time, createdBy, title string, privatePermissions bool, filters Filters) ([]*Recommendation, Metadata, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), r.id, r.created_at, r.user_id, r.artist, r.title, r.cover_url, r.yt_link, r.spotify_link, r.comment, r.is_public, r.version,
 		       u.id, u.name, u.username
@@ -181,7 +181,7 @@ func (m RecommendationModel) GetAll(createdAt time.Time, createdBy, title string
 		ORDER BY %s %s, r.id DESC
 		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{createdAt, createdBy, title, privatePermissions, filters.limit(), filters.offset()}
@@ -229,3 +229,71 @@ func (m RecommendationModel) GetAll(createdAt time.Time, createdBy, title string
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return recommendations, metadata, nil
 }
+
+func (m RecommendationModel) GetFeed(ctx context.Context, followerID int, privatePermissions bool, filters KeysetFilters) ([]*Recommendation, KeysetMetadata, error) {
+	query := `
+		SELECT r.id, r.created_at, r.user_id, r.artist, r.title, r.cover_url, r.yt_link, r.spotify_link, r.comment, r.is_public, r.version,
+		       u.id, u.name, u.username
+		FROM recommendations r
+		INNER JOIN users u ON r.user_id = u.id
+		INNER JOIN follows f ON f.followed_id = r.user_id
+		WHERE f.follower_id = $1
+		AND ($2 = true OR r.is_public = true)
+		AND ($3 = 0 OR (r.created_at, r.id) < ($4, $3))
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $5`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// fetch one extra row to find out whether there is a next page
+	args := []any{followerID, privatePermissions, filters.After.ID, filters.After.CreatedAt, filters.PageSize + 1}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, KeysetMetadata{}, err
+	}
+
+	defer rows.Close()
+
+	recommendations := []*Recommendation{}
+
+	for rows.Next() {
+		var recommendation Recommendation
+		recommendation.CreatedBy = &User{}
+
+		err := rows.Scan(
+			&recommendation.ID,
+			&recommendation.CreatedAt,
+			&recommendation.UserID,
+			&recommendation.Artist,
+			&recommendation.Title,
+			&recommendation.CoverURL,
+			&recommendation.YTLink,
+			&recommendation.SpotifyLink,
+			&recommendation.Comment,
+			&recommendation.IsPublic,
+			&recommendation.Version,
+			&recommendation.CreatedBy.ID,
+			&recommendation.CreatedBy.Name,
+			&recommendation.CreatedBy.Username,
+		)
+		if err != nil {
+			return nil, KeysetMetadata{}, err
+		}
+		recommendations = append(recommendations, &recommendation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, KeysetMetadata{}, err
+	}
+
+	metadata := KeysetMetadata{PageSize: filters.PageSize}
+	if len(recommendations) > filters.PageSize {
+		recommendations = recommendations[:filters.PageSize]
+		last := recommendations[len(recommendations)-1]
+		metadata.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return recommendations, metadata, nil
+}
@@ -36,10 +36,10 @@ func ValidateReservation(v *validator.Validator, reservation *Reservation) {
 }
 
 type ReservationModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
-func (m ReservationModel) Insert(reservation *Reservation) error {
+func (m ReservationModel) Insert(ctx context.Context, reservation *Reservation) error {
 	query := `
 		INSERT INTO reservations (user_id, title, description, start_time, end_time, color, parent_reservation_id)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -60,13 +60,13 @@ func (m ReservationModel) Insert(reservation *Reservation) error {
 		parentID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&reservation.ID, &reservation.CreatedAt, &reservation.Version)
 }
 
-func (m ReservationModel) Get(id int) (*Reservation, error) {
+func (m ReservationModel) Get(ctx context.Context, id int) (*Reservation, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -82,7 +82,7 @@ func (m ReservationModel) Get(id int) (*Reservation, error) {
 	reservation.CreatedBy = &User{}
 
 	var parentID sql.NullInt64
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -116,7 +116,7 @@ func (m ReservationModel) Get(id int) (*Reservation, error) {
 	return &reservation, nil
 }
 
-func (m ReservationModel) Update(reservation *Reservation) error {
+func (m ReservationModel) Update(ctx context.Context, reservation *Reservation) error {
 	query := `
         UPDATE reservations 
         SET title = $1, description = $2, start_time = $3, end_time = $4, color = $5, parent_reservation_id = $6, version = version + 1
@@ -139,7 +139,7 @@ func (m ReservationModel) Update(reservation *Reservation) error {
 		reservation.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&reservation.Version)
@@ -155,7 +155,7 @@ func (m ReservationModel) Update(reservation *Reservation) error {
 	return nil
 }
 
-func (m ReservationModel) Delete(id int) error {
+func (m ReservationModel) Delete(ctx context.Context, id int) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -164,7 +164,7 @@ func (m ReservationModel) Delete(id int) error {
 		DELETE FROM reservations
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -183,7 +183,7 @@ func (m ReservationModel) Delete(id int) error {
 	return nil
 }
 
-func (m ReservationModel) GetAll(createdBy string, filters Filters) ([]*Reservation, Metadata, error) {
+func (m ReservationModel) GetAll(ctx context.Context, createdBy string, filters Filters) ([]*Reservation, Metadata, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), r.id, r.created_at, r.user_id, r.title, r.description, r.start_time, r.end_time, r.color, r.parent_reservation_id, r.version,
 		       u.id, u.name, u.username
@@ -193,7 +193,7 @@ func (m ReservationModel) GetAll(createdBy string, filters Filters) ([]*Reservat
 		ORDER BY %s %s, r.id DESC
 		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{createdBy, filters.limit(), filters.offset()}
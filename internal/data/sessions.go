@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Session is a login as the user sees it: an authentication token, or with signed access tokens the
+// refresh token family started by the login.
+type Session struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
+}
+
+// GetAllSessionsForUser lists the user's active sessions. current is the caller's authentication token
+// or refresh token family and marks the session the request was made with.
+func (m TokenModel) GetAllSessionsForUser(ctx context.Context, userID int, current string) ([]*Session, error) {
+	query := `
+        SELECT tokens.id, COALESCE(family.created_at, tokens.created_at), tokens.last_used_at, tokens.expiry,
+               tokens.user_agent, tokens.ip, tokens.hash = $4 OR tokens.family = $5
+        FROM tokens
+        LEFT JOIN LATERAL (
+            SELECT MIN(f.created_at) AS created_at FROM tokens f WHERE f.family = tokens.family
+        ) family ON true
+        WHERE tokens.user_id = $1 AND tokens.expiry > NOW()
+        AND (tokens.scope = $2 OR (tokens.scope = $3 AND tokens.used_at IS NULL))
+        ORDER BY COALESCE(tokens.last_used_at, tokens.created_at) DESC, tokens.id DESC`
+
+	currentHash := sha256.Sum256([]byte(current))
+	args := []any{userID, ScopeAuthentication, ScopeRefresh, currentHash[:], current}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var isCurrent sql.NullBool
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&isCurrent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		session.Current = isCurrent.Bool
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one of the user's sessions. For a refresh token the whole family goes, signed
+// access tokens already issued from it stay valid until they expire.
+func (m TokenModel) DeleteSession(ctx context.Context, userID, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $1 AND scope IN ($3, $4)
+        AND (id = $2 OR family = (SELECT family FROM tokens WHERE id = $2 AND user_id = $1))`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, id, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// LastUsedBatcher remembers when sessions and API keys were used and writes it out in bulk on Flush, so
+// that authenticating a request doesn't cost a database write.
+type LastUsedBatcher struct {
+	db       *DB
+	mu       sync.Mutex
+	hashes   map[[sha256.Size]byte]time.Time
+	families map[string]time.Time
+	apiKeys  map[[sha256.Size]byte]time.Time
+}
+
+func NewLastUsedBatcher(db *DB) *LastUsedBatcher {
+	return &LastUsedBatcher{
+		db:       db,
+		hashes:   make(map[[sha256.Size]byte]time.Time),
+		families: make(map[string]time.Time),
+		apiKeys:  make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Record notes the use of an authentication token.
+func (b *LastUsedBatcher) Record(tokenPlaintext string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.hashes[sha256.Sum256([]byte(tokenPlaintext))] = time.Now()
+	b.mu.Unlock()
+}
+
+// RecordFamily notes the use of a signed access token issued from the refresh token family.
+func (b *LastUsedBatcher) RecordFamily(family string) {
+	if b == nil || family == "" {
+		return
+	}
+
+	b.mu.Lock()
+	b.families[family] = time.Now()
+	b.mu.Unlock()
+}
+
+// RecordAPIKey notes the use of an API key.
+func (b *LastUsedBatcher) RecordAPIKey(plaintext string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.apiKeys[sha256.Sum256([]byte(plaintext))] = time.Now()
+	b.mu.Unlock()
+}
+
+// Flush writes out everything recorded since the previous flush and returns the number of rows updated.
+func (b *LastUsedBatcher) Flush(ctx context.Context) (int64, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	hashes, families, apiKeys := b.hashes, b.families, b.apiKeys
+	b.hashes = make(map[[sha256.Size]byte]time.Time)
+	b.families = make(map[string]time.Time)
+	b.apiKeys = make(map[[sha256.Size]byte]time.Time)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var updated int64
+
+	if len(hashes) > 0 {
+		query := `
+            UPDATE tokens
+            SET last_used_at = to_timestamp(v.used_at)
+            FROM unnest($1::bytea[], $2::bigint[]) AS v(hash, used_at)
+            WHERE tokens.hash = v.hash`
+
+		keys := make([][]byte, 0, len(hashes))
+		usedAt := make([]int64, 0, len(hashes))
+		for hash, t := range hashes {
+			keys = append(keys, hash[:])
+			usedAt = append(usedAt, t.Unix())
+		}
+
+		result, err := b.db.ExecContext(ctx, query, pq.Array(keys), pq.Array(usedAt))
+		if err != nil {
+			return updated, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return updated, err
+		}
+		updated += rowsAffected
+	}
+
+	if len(families) > 0 {
+		query := `
+            UPDATE tokens
+            SET last_used_at = to_timestamp(v.used_at)
+            FROM unnest($1::text[], $2::bigint[]) AS v(family, used_at)
+            WHERE tokens.family = v.family AND tokens.used_at IS NULL`
+
+		keys := make([]string, 0, len(families))
+		usedAt := make([]int64, 0, len(families))
+		for family, t := range families {
+			keys = append(keys, family)
+			usedAt = append(usedAt, t.Unix())
+		}
+
+		result, err := b.db.ExecContext(ctx, query, pq.Array(keys), pq.Array(usedAt))
+		if err != nil {
+			return updated, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return updated, err
+		}
+		updated += rowsAffected
+	}
+
+	if len(apiKeys) > 0 {
+		query := `
+            UPDATE api_keys
+            SET last_used_at = to_timestamp(v.used_at)
+            FROM unnest($1::bytea[], $2::bigint[]) AS v(hash, used_at)
+            WHERE api_keys.hash = v.hash`
+
+		keys := make([][]byte, 0, len(apiKeys))
+		usedAt := make([]int64, 0, len(apiKeys))
+		for hash, t := range apiKeys {
+			keys = append(keys, hash[:])
+			usedAt = append(usedAt, t.Unix())
+		}
+
+		result, err := b.db.ExecContext(ctx, query, pq.Array(keys), pq.Array(usedAt))
+		if err != nil {
+			return updated, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return updated, err
+		}
+		updated += rowsAffected
+	}
+
+	return updated, nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"api.ukrop.pl/internal/validator"
@@ -13,14 +14,23 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeEmailChange    = "email-change"
+	ScopeDeletion       = "deletion"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
 )
 
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int       `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    string    `json:"-"` // refresh tokens rotated from the same login share a family
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
 }
 
 func generateToken(userID int, ttl time.Duration, scope string) *Token {
@@ -43,38 +53,153 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB       *DB
+	LastUsed *LastUsedBatcher // optional, nil stops recording when sessions were last used
 }
 
-func (m TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int, ttl time.Duration, scope string) (*Token, error) {
 	token := generateToken(userID, ttl, scope)
 
-	err := m.Insert(token)
+	err := m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+// NewSession creates an authentication token remembering the client it was issued to.
+func (m TokenModel) NewSession(ctx context.Context, userID int, ttl time.Duration, userAgent, ip string) (*Token, error) {
+	token := generateToken(userID, ttl, ScopeAuthentication)
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+// NewRefresh creates a refresh token in the given family, starting a new family when it is empty.
+func (m TokenModel) NewRefresh(ctx context.Context, userID int, ttl time.Duration, family, userAgent, ip string) (*Token, error) {
+	token := generateToken(userID, ttl, ScopeRefresh)
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	token.Family = family
+	if token.Family == "" {
+		token.Family = rand.Text()
+	}
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent, ip) 
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.UserAgent, token.IP}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int) error {
 	query := `
         DELETE FROM tokens 
         WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllSessionsForUser logs the user out everywhere by deleting their authentication and refresh tokens.
+func (m TokenModel) DeleteAllSessionsForUser(ctx context.Context, userID int) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE scope IN ($1, $2) AND user_id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID)
+	return err
+}
+
+// UseRefresh marks a refresh token as used and returns it. Presenting a token which was already used means
+// it leaked, so the whole family is revoked and ErrTokenReused is returned.
+func (m TokenModel) UseRefresh(ctx context.Context, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        UPDATE tokens
+        SET used_at = NOW()
+        WHERE hash = $1 AND scope = $2 AND expiry > $3 AND used_at IS NULL
+        RETURNING user_id, expiry, family`
+
+	token := Token{Plaintext: tokenPlaintext, Hash: tokenHash[:], Scope: ScopeRefresh}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+        SELECT family
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL`
+
+	err = m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = m.DeleteFamily(ctx, token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTokenReused
+}
+
+// DeleteExpired removes tokens of every scope past their expiry.
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+        DELETE FROM tokens
+        WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m TokenModel) DeleteFamily(ctx context.Context, family string) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
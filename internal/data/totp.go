@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RecoveryCodeCount is how many single-use recovery codes are issued when 2FA gets enabled.
+const RecoveryCodeCount = 10
+
+var ErrTOTPEnabled = errors.New("totp already enabled")
+
+type TOTP struct {
+	Secret      string
+	Enabled     bool
+	LastCounter int64 // time step of the last accepted code, codes for it and earlier steps are rejected
+}
+
+// NewRecoveryCodes returns plaintext recovery codes in the form "abcde-fghij".
+func NewRecoveryCodes() []string {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		text := strings.ToLower(rand.Text())
+		codes[i] = text[:5] + "-" + text[5:10]
+	}
+	return codes
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+type TOTPModel struct {
+	DB *DB
+}
+
+func (m TOTPModel) Get(ctx context.Context, userID int) (*TOTP, error) {
+	query := `
+		SELECT secret, enabled, last_counter
+		FROM users_totp
+		WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&totp.Secret, &totp.Enabled, &totp.LastCounter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// Enrol stores a new secret awaiting confirmation, replacing any earlier unconfirmed one.
+func (m TOTPModel) Enrol(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_counter = 0
+		WHERE users_totp.enabled = false`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+
+	return nil
+}
+
+// Enable confirms the pending secret with the time step of the first valid code and replaces the
+// user's recovery codes.
+func (m TOTPModel) Enable(ctx context.Context, userID int, counter int64, recoveryCodes []string) error {
+	query := `
+		WITH enabled AS (
+			UPDATE users_totp
+			SET enabled = true, last_counter = $2
+			WHERE user_id = $1 AND enabled = false
+			RETURNING user_id
+		), deleted AS (
+			DELETE FROM totp_recovery_codes
+			WHERE user_id IN (SELECT user_id FROM enabled)
+		)
+		INSERT INTO totp_recovery_codes (hash, user_id)
+		SELECT unnest($3::bytea[]), user_id FROM enabled`
+
+	hashes := make([][]byte, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter, pq.Array(hashes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m TOTPModel) Disable(ctx context.Context, userID int) error {
+	query := `
+		WITH codes AS (
+			DELETE FROM totp_recovery_codes
+			WHERE user_id = $1
+		)
+		DELETE FROM users_totp
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UseCounter records the time step of an accepted code. It reports false when a code for that
+// or a later step was already used, so each code works only once.
+func (m TOTPModel) UseCounter(ctx context.Context, userID int, counter int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_counter = $2
+		WHERE user_id = $1 AND enabled = true AND last_counter < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode deletes the recovery code and reports whether it existed.
+func (m TOTPModel) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	query := `
+		DELETE FROM totp_recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
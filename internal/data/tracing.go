@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("api.ukrop.pl/internal/data")
+
+// DB traces every query as a child of the span in its context, usually the one of the request the
+// query serves. Spans are named after the model method issuing the query, like UserModel.GetByEmail.
+type DB struct {
+	*sql.DB
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	recordQueryError(span, err)
+	return result, err
+}
+
+// QueryContext leaves the span open until the rows are closed, so that it covers reading them as well.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		recordQueryError(span, err)
+		span.End()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, span: span}, nil
+}
+
+// Rows ends the span of its query on Close, recording any error met while iterating.
+type Rows struct {
+	*sql.Rows
+	span trace.Span
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+
+	if r.span != nil {
+		recordQueryError(r.span, errors.Join(r.Rows.Err(), err))
+		r.span.End()
+		r.span = nil
+	}
+
+	return err
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	recordQueryError(span, row.Err())
+	return row
+}
+
+// startQuerySpan must be called straight from a DB method, so that the caller two frames up is the model method.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+		),
+	)
+
+	// looking up the caller isn't free, so only bother when the span is going to be exported
+	if span.IsRecording() {
+		if pc, _, _, ok := runtime.Caller(2); ok {
+			name := runtime.FuncForPC(pc).Name() // api.ukrop.pl/internal/data.UserModel.GetByEmail
+			name = name[strings.LastIndex(name, "/")+1:]
+			span.SetName(name[strings.Index(name, ".")+1:])
+		}
+	}
+
+	return ctx, span
+}
+
+func recordQueryError(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
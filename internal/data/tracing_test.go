@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errBrokenRow = errors.New("broken row")
+
+// fakeDriver answers every query with two rows, followed by errBrokenRow when the query is "broken".
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{broken: query == "broken"}, nil
+}
+
+type fakeRows struct {
+	broken bool
+	read   int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read == 2 {
+		if r.broken {
+			return errBrokenRow
+		}
+		return io.EOF
+	}
+
+	r.read++
+	dest[0] = int64(r.read)
+	return nil
+}
+
+func init() {
+	sql.Register("tracingtest", fakeDriver{})
+}
+
+func TestQuerySpanCoversRows(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	sqlDB, err := sql.Open("tracingtest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	db := &DB{DB: sqlDB}
+
+	for _, query := range []string{"ok", "broken"} {
+		t.Run(query, func(t *testing.T) {
+			rows, err := db.QueryContext(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for rows.Next() {
+				if n := len(recorder.Ended()); n != 0 {
+					t.Fatalf("%d spans ended while reading the rows", n)
+				}
+			}
+
+			rows.Close()
+			rows.Close() // deferred closes often follow an explicit one
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("%d spans ended, want 1", len(spans))
+			}
+
+			status := spans[0].Status().Code
+			if query == "broken" && status != codes.Error {
+				t.Errorf("status = %s, want the row error recorded", status)
+			}
+			if query == "ok" && status == codes.Error {
+				t.Errorf("status = %s, want no error", status)
+			}
+
+			recorder.Reset()
+		})
+	}
+}
@@ -2,12 +2,17 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"api.ukrop.pl/internal/validator"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,6 +31,8 @@ type User struct {
 	Username  string    `json:"username"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated,omitzero"`
+	Bio       string    `json:"bio,omitzero"`
+	AvatarURL string    `json:"avatar_url,omitzero"`
 	Version   int       `json:"-"`
 }
 
@@ -64,6 +71,21 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+var (
+	dummyPassword     password
+	dummyPasswordOnce sync.Once
+)
+
+// SimulatePasswordMatch spends the same bcrypt work as password.Matches, so that a login for an unknown
+// email takes as long as one with a wrong password and doesn't reveal which emails are registered.
+func SimulatePasswordMatch(plaintextPassword string) {
+	dummyPasswordOnce.Do(func() {
+		_ = dummyPassword.Set(rand.Text())
+	})
+
+	_, _ = dummyPassword.Matches(plaintextPassword)
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -83,6 +105,12 @@ func ValidateUsername(v *validator.Validator, username string) {
 	v.Check(validator.AlphanumericRX.MatchString(username), "username", "must only contain alphanumeric characters, hyphens or underscores")
 }
 
+// ValidateLogin checks an identifier that is either an email or a username.
+func ValidateLogin(v *validator.Validator, login string) {
+	v.Check(login != "", "login", "must be provided")
+	v.Check(len(login) <= 500, "login", "must not be more than 500 bytes long")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
@@ -91,6 +119,12 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	ValidateEmail(v, user.Email)
 
+	v.Check(len(user.Bio) <= 1000, "bio", "must not be more than 1000 bytes long")
+
+	if user.AvatarURL != "" {
+		v.Check(validator.IsURL(user.AvatarURL), "avatar_url", "must be a valid http or https URL")
+	}
+
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
@@ -101,18 +135,19 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB    *DB
+	Cache *PermissionCache // invalidated whenever users get deleted
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (name, username, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (name, username, email, password_hash, activated, activated_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $5 THEN NOW() END)
 		RETURNING id, created_at, activated`
 
 	args := []any{user.Name, user.Username, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Activated)
@@ -120,7 +155,7 @@ func (m UserModel) Insert(user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
 			return ErrDuplicateUsername
 		default:
 			return err
@@ -129,15 +164,54 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id int) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-		SELECT id, created_at, name, username, email, password_hash, activated, version
+		SELECT id, created_at, name, username, email, password_hash, activated, bio, avatar_url, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, username, email, password_hash, activated, bio, avatar_url, version
 		FROM users
 		WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -148,6 +222,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
 		&user.Version,
 	)
 
@@ -162,24 +238,36 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetByUsername(username string) (*User, error) {
+// GetByLogin looks the user up by email or by username. Usernames cannot contain "@", so the
+// identifier is unambiguous.
+func (m UserModel) GetByLogin(ctx context.Context, login string) (*User, error) {
+	if strings.Contains(login, "@") {
+		return m.GetByEmail(ctx, login)
+	}
+	return m.GetByUsername(ctx, login)
+}
+
+func (m UserModel) GetByUsername(ctx context.Context, username string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, username, email, password_hash, activated, version
+		SELECT id, created_at, name, username, email, password_hash, activated, bio, avatar_url, version
 		FROM users
 		WHERE username = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, username).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
+		&user.Username,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
 		&user.Version,
 	)
 
@@ -194,11 +282,12 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
         UPDATE users 
-        SET name = $1, username=$2, email = $3, password_hash = $4, activated = $5, version = version + 1
-        WHERE id = $6 AND version = $7
+        SET name = $1, username=$2, email = $3, password_hash = $4, activated = $5, bio = $6, avatar_url = $7, version = version + 1,
+            activated_at = CASE WHEN $5 THEN COALESCE(activated_at, NOW()) ELSE activated_at END
+        WHERE id = $8 AND version = $9
         RETURNING version`
 
 	args := []any{
@@ -207,11 +296,13 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Bio,
+		user.AvatarURL,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -230,11 +321,102 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.Cache.Invalidate(id)
+	return nil
+}
+
+// DeleteStaleUnactivated removes accounts that were never activated within the grace period after
+// registering. Accounts deactivated later on are kept.
+func (m UserModel) DeleteStaleUnactivated(ctx context.Context, grace time.Duration) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE activated_at IS NULL AND activated = false AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-grace))
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if rowsAffected > 0 {
+		m.Cache.InvalidateAll()
+	}
+
+	return rowsAffected, err
+}
+
+// SetPendingEmail stores an email address which replaces the current one once ConfirmPendingEmail is called.
+func (m UserModel) SetPendingEmail(ctx context.Context, userID int, email string) error {
+	query := `
+		UPDATE users
+		SET pending_email = $1
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, userID)
+	return err
+}
+
+func (m UserModel) ConfirmPendingEmail(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, version = version + 1
+		WHERE id = $1 AND pending_email IS NOT NULL
+		RETURNING email, version`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Email, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-        SELECT users.id, users.created_at, users.name, users.username, users.email, users.password_hash, users.activated, users.version
+        SELECT users.id, users.created_at, users.name, users.username, users.email, users.password_hash, users.activated, users.bio, users.avatar_url, users.version
         FROM users
         INNER JOIN tokens
         ON users.id = tokens.user_id
@@ -246,7 +428,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -257,6 +439,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
 		&user.Version,
 	)
 	if err != nil {
@@ -270,3 +454,110 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// likeEscaper escapes the LIKE wildcards, so that a search for "a_b" or "100%" matches only itself.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// GetAll searches users by username, name or email. activated filters by activation status when not empty ("true" or "false").
+func (m UserModel) GetAll(ctx context.Context, search, activated string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, username, email, activated, bio, avatar_url, version
+		FROM users
+		WHERE (username ILIKE '%%' || $1 || '%%' ESCAPE '\' OR name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR email ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+		AND (activated::text = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{escapeLike(search), activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Username,
+			&user.Email,
+			&user.Activated,
+			&user.Bio,
+			&user.AvatarURL,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// GetForTokenWithPermissions loads the token owner together with their effective permissions in a single query.
+func (m UserModel) GetForTokenWithPermissions(ctx context.Context, tokenScope, tokenPlaintext string) (*User, Permissions, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT users.id, users.created_at, users.name, users.username, users.email, users.password_hash, users.activated, users.bio, users.avatar_url, users.version,
+               ARRAY(` + effectivePermissions + `)
+        FROM users
+        INNER JOIN tokens
+        ON users.id = tokens.user_id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2 
+        AND tokens.expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+	var permissions Permissions
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.Version,
+		pq.Array((*[]string)(&permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &user, permissions, nil
+}
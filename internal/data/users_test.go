@@ -0,0 +1,23 @@
+package data
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"", ""},
+		{"ukrop", "ukrop"},
+		{"a_b", `a\_b`},
+		{"100%", `100\%`},
+		{`back\slash`, `back\\slash`},
+		{`\%_`, `\\\%\_`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLike(tt.search); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
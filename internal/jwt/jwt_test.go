@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secret = []byte("01234567890123456789012345678901")
+	now    = time.Unix(1700000000, 0)
+)
+
+func validClaims() Claims {
+	return Claims{
+		Issuer:      "api.ukrop.pl",
+		Subject:     "42",
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(15 * time.Minute).Unix(),
+		Username:    "user",
+		Permissions: []string{"recommendations:read"},
+		SessionID:   "family",
+	}
+}
+
+// signed builds a token from raw header and payload JSON, signed with key.
+func signed(headerJSON, payloadJSON string, key []byte) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(headerJSON)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payloadJSON))
+	return unsigned + "." + signature(unsigned, key)
+}
+
+func TestSignParse(t *testing.T) {
+	token, err := Sign(validClaims(), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LooksLikeJWT(token) {
+		t.Errorf("%q doesn't look like a JWT", token)
+	}
+
+	claims, err := Parse(token, secret, "api.ukrop.pl", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := validClaims()
+	if claims.Subject != want.Subject || claims.SessionID != want.SessionID || len(claims.Permissions) != 1 {
+		t.Errorf("claims = %+v, want %+v", claims, want)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	token, err := Sign(validClaims(), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	tampered := validClaims()
+	tampered.Permissions = append(tampered.Permissions, "users:manage")
+	tamperedPayload, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		issuer  string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "tampered payload",
+			token:   parts[0] + "." + base64.RawURLEncoding.EncodeToString(tamperedPayload) + "." + parts[2],
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong key",
+			token:   signed(`{"alg":"HS256","typ":"JWT"}`, string(payload), []byte("another secret of at least 32 bytes")),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong issuer",
+			token:   token,
+			issuer:  "someone.else",
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "expired",
+			token:   token,
+			now:     now.Add(15 * time.Minute),
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:    "alg none",
+			token:   base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".",
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "alg HS512",
+			token:   signed(`{"alg":"HS512","typ":"JWT"}`, string(payload), secret),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "empty",
+			token:   "",
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "two segments",
+			token:   parts[0] + "." + parts[1],
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "four segments",
+			token:   token + "." + parts[2],
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "payload not base64",
+			token:   parts[0] + ".!!!." + signature(parts[0]+".!!!", secret),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "payload not JSON",
+			token:   signed(`{"alg":"HS256","typ":"JWT"}`, "not json", secret),
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := tt.issuer
+			if issuer == "" {
+				issuer = "api.ukrop.pl"
+			}
+			at := tt.now
+			if at.IsZero() {
+				at = now
+			}
+
+			claims, err := Parse(tt.token, secret, issuer, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v (claims %+v)", err, tt.wantErr, claims)
+			}
+		})
+	}
+}
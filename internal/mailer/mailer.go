@@ -2,10 +2,18 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/wneessen/go-mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	ht "html/template"
 	tt "text/template"
@@ -42,7 +50,48 @@ func New(host string, port int, username, password, sender string) (*Mailer, err
 	return mailer, nil
 }
 
-func (m *Mailer) Send(recipient string, templateFile string, data any) error {
+var tracer = otel.Tracer("api.ukrop.pl/internal/mailer")
+
+var sends = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ukrop_mailer_sends_total",
+	Help: "Emails sent, by template and result.",
+}, []string{"template", "result"})
+
+// Send renders the template and sends the email, retrying a few times. Mail usually goes out after the
+// request that triggered it has finished, so ctx only carries the trace and never cancels the send.
+func (m *Mailer) Send(ctx context.Context, recipient string, templateFile string, data any) error {
+	ctx, span := tracer.Start(context.WithoutCancel(ctx), "smtp.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mail.template", templateFile)),
+	)
+	defer span.End()
+
+	err := m.send(ctx, recipient, templateFile, data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	sends.WithLabelValues(templateFile, result).Inc()
+
+	return err
+}
+
+// Ping connects and authenticates to the SMTP server without sending anything.
+func (m *Mailer) Ping(ctx context.Context) error {
+	client, err := m.client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.client.CloseWithSMTPClient(client)
+}
+
+func (m *Mailer) send(ctx context.Context, recipient string, templateFile string, data any) error {
 	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -84,6 +133,13 @@ func (m *Mailer) Send(recipient string, templateFile string, data any) error {
 	}
 
 	msg.Subject(subject.String())
+
+	// a traceparent header ties a relay that traces to the send, baggage is left out as it would reach the recipient
+	headers := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, headers)
+	for key, value := range headers {
+		msg.SetGenHeader(mail.Header(key), value)
+	}
 	msg.SetBodyString(mail.TypeTextPlain, plainBody.String())
 	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
 
@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// Claims are the ID token claims needed to sign a user in.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience accepts both forms of the aud claim, a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(b, &many)
+	*a = many
+	return err
+}
+
+// Client signs users in with the authorization code flow and PKCE against a single provider.
+type Client struct {
+	issuer     string
+	config     oauth2.Config
+	httpClient *http.Client
+}
+
+// New discovers the provider's endpoints from its issuer URL. The issuer and its token endpoint must
+// use https, since ID tokens are trusted for having come over TLS from the provider. allowHTTP lifts
+// that for a local stand-in provider during development and tests.
+func New(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, allowHTTP bool) (*Client, error) {
+	c := &Client{
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if !secureURL(c.issuer, allowHTTP) {
+		return nil, fmt.Errorf("oidc issuer %q must be an https URL", issuer)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed: %s", res.Status)
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&discovery)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	if discovery.Issuer != c.issuer {
+		return nil, fmt.Errorf("oidc discovery failed: provider claims to be %q, not %q", discovery.Issuer, c.issuer)
+	}
+
+	if !secureURL(discovery.TokenEndpoint, allowHTTP) {
+		return nil, fmt.Errorf("oidc discovery failed: token endpoint %q must be an https URL", discovery.TokenEndpoint)
+	}
+
+	c.config = oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+		Scopes: []string{"openid", "email", "profile"},
+	}
+
+	return c, nil
+}
+
+func secureURL(rawURL string, allowHTTP bool) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return u.Scheme == "https" || allowHTTP && u.Scheme == "http"
+}
+
+// AuthCodeURL returns the provider URL to send the user to. verifier is the PKCE code verifier, which
+// is only sent in hashed form here and in plain once the code gets exchanged.
+func (c *Client) AuthCodeURL(state, nonce, verifier string) string {
+	return c.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+// Exchange trades the authorization code for the ID token and returns its verified claims.
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+
+	token, err := c.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	return c.verify(rawIDToken, nonce, time.Now())
+}
+
+// verify checks the claims of an ID token received straight from the token endpoint. The TLS
+// connection to the provider vouches for its origin, so the signature is not checked, as allowed
+// by OpenID Connect Core 3.1.3.7.
+func (c *Client) verify(rawIDToken, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != c.issuer:
+		return nil, ErrInvalidIDToken
+	case !slices.Contains(claims.Audience, c.config.ClientID):
+		return nil, ErrInvalidIDToken
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID:
+		return nil, ErrInvalidIDToken
+	case now.Unix() >= claims.ExpiresAt:
+		return nil, ErrInvalidIDToken
+	case claims.Nonce != nonce:
+		return nil, ErrInvalidIDToken
+	case claims.Subject == "":
+		return nil, ErrInvalidIDToken
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,123 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"api.ukrop.pl/internal/oidc/oidctest"
+	"golang.org/x/oauth2"
+)
+
+func TestNewRequiresHTTPS(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	_, err := New(context.Background(), provider.URL, "client", "secret", "https://app.example.com/callback", false)
+	if err == nil {
+		t.Fatal("plain http issuer accepted")
+	}
+
+	_, err = New(context.Background(), provider.URL, "client", "secret", "https://app.example.com/callback", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	c, err := New(context.Background(), provider.URL, "client", "secret", "https://app.example.com/callback", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := oidctest.Identity{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice", PreferredUsername: "alice"}
+
+	tests := []struct {
+		name     string
+		verifier string // sent with the code instead of the one the challenge was made from
+		nonce    string // expected instead of the one sent to the provider
+		wantErr  bool
+	}{
+		{name: "valid"},
+		{name: "wrong verifier", verifier: oauth2.GenerateVerifier(), wantErr: true},
+		{name: "wrong nonce", nonce: "other", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := oauth2.GenerateVerifier()
+
+			code, err := provider.Authorize(c.AuthCodeURL("state", "nonce", verifier), identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			nonce := "nonce"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			claims, err := c.Exchange(context.Background(), code, verifier, nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("exchange succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != identity.Subject || claims.Email != identity.Email || !claims.EmailVerified || claims.PreferredUsername != identity.PreferredUsername {
+				t.Errorf("claims = %+v, want %+v", claims, identity)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c := &Client{issuer: "https://id.example.com", config: oauth2.Config{ClientID: "client"}}
+	now := time.Unix(1700000000, 0)
+
+	valid := Claims{Issuer: c.issuer, Subject: "1234", Audience: audience{"client"}, ExpiresAt: now.Unix() + 60, Nonce: "nonce"}
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+		want   bool
+	}{
+		{"valid", func(*Claims) {}, true},
+		{"other issuer", func(c *Claims) { c.Issuer = "https://evil.example.com" }, false},
+		{"other audience", func(c *Claims) { c.Audience = audience{"other"} }, false},
+		{"several audiences without azp", func(c *Claims) { c.Audience = audience{"client", "other"} }, false},
+		{"several audiences with azp", func(c *Claims) { c.Audience = audience{"client", "other"}; c.AuthorizedParty = "client" }, true},
+		{"expired", func(c *Claims) { c.ExpiresAt = now.Unix() }, false},
+		{"other nonce", func(c *Claims) { c.Nonce = "other" }, false},
+		{"no subject", func(c *Claims) { c.Subject = "" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid
+			tt.modify(&claims)
+
+			payload, err := json.Marshal(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.verify("e30."+base64.RawURLEncoding.EncodeToString(payload)+".", "nonce", now)
+			if got := err == nil; got != tt.want {
+				t.Errorf("valid = %t, want %t (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
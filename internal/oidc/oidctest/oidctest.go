@@ -0,0 +1,161 @@
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Identity is the user signing in at the provider.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type grant struct {
+	clientID  string
+	challenge string
+	claims    map[string]any
+}
+
+// Provider is a stand-in OpenID Connect provider serving discovery and the token endpoint over plain
+// http. Its ID tokens aren't signed.
+type Provider struct {
+	URL    string
+	server *httptest.Server
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+func NewProvider() *Provider {
+	p := &Provider{codes: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("POST /token", p.tokenHandler)
+
+	p.server = httptest.NewServer(mux)
+	p.URL = p.server.URL
+
+	return p
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// Authorize plays the user signing in as identity at the authorization URL and returns the code the
+// provider redirects them back with.
+func (p *Provider) Authorize(authURL string, identity Identity) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+
+	switch {
+	case u.Scheme+"://"+u.Host+u.Path != p.URL+"/authorize":
+		return "", errors.New("not the authorization endpoint")
+	case query.Get("response_type") != "code":
+		return "", errors.New("response_type must be code")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", errors.New("missing S256 code challenge")
+	case query.Get("state") == "" || query.Get("nonce") == "":
+		return "", errors.New("missing state or nonce")
+	}
+
+	code := rand.Text()
+
+	p.mu.Lock()
+	p.codes[code] = grant{
+		clientID:  query.Get("client_id"),
+		challenge: query.Get("code_challenge"),
+		claims: map[string]any{
+			"iss":                p.URL,
+			"sub":                identity.Subject,
+			"aud":                query.Get("client_id"),
+			"exp":                time.Now().Add(5 * time.Minute).Unix(),
+			"nonce":              query.Get("nonce"),
+			"email":              identity.Email,
+			"email_verified":     identity.EmailVerified,
+			"name":               identity.Name,
+			"preferred_username": identity.PreferredUsername,
+		},
+	}
+	p.mu.Unlock()
+
+	return code, nil
+}
+
+func (p *Provider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+	})
+}
+
+func (p *Provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	clientID, _, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code" || !ok:
+		tokenError(w, "invalid_grant")
+		return
+	case clientID != grant.clientID:
+		tokenError(w, "invalid_client")
+		return
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, err := json.Marshal(grant.claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".",
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
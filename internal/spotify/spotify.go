@@ -3,14 +3,21 @@ package spotify
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
 	"github.com/zmb3/spotify/v2"
 )
 
+var tracer = otel.Tracer("api.ukrop.pl/internal/spotify")
+
 type SearchResult struct {
 	Artist       string `json:"artist"`
 	Title        string `json:"title"`
@@ -20,24 +27,43 @@ type SearchResult struct {
 }
 
 type Client struct {
-	client *spotify.Client
+	client     *spotify.Client
+	config     *clientcredentials.Config
+	httpClient *http.Client
 }
 
 func New(apiID, apiSecret string) (*Client, error) {
-	ctx := context.Background()
+	// oauth2 takes the client for both the token requests and the API calls from the context
+	httpClient := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
 	config := &clientcredentials.Config{
 		ClientID:     apiID,
 		ClientSecret: apiSecret,
 		TokenURL:     spotifyauth.TokenURL,
 	}
 	token := config.TokenSource(ctx)
-	httpClient := oauth2.NewClient(ctx, token)
-	return &Client{client: spotify.New(httpClient)}, nil
+	return &Client{client: spotify.New(oauth2.NewClient(ctx, token)), config: config, httpClient: httpClient}, nil
+}
+
+// Ping checks the client credentials by fetching a fresh access token.
+func (s *Client) Ping(ctx context.Context) error {
+	_, err := s.config.Token(context.WithValue(ctx, oauth2.HTTPClient, s.httpClient))
+	if err != nil {
+		return fmt.Errorf("spotify ping failed: %w", err)
+	}
+
+	return nil
 }
 
 func (s *Client) SearchMusic(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+	ctx, span := tracer.Start(ctx, "spotify.search", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
 	response, err := s.client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(maxResults))
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("spotify search call failed: %w", err)
 	}
 
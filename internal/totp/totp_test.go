@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// the RFC 6238 appendix B seed for HMAC-SHA1
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCodeRFC6238(t *testing.T) {
+	// the appendix lists 8 digit codes, 6 digit ones are their last 6 digits
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := GenerateCode(rfcSecret, time.Unix(tt.time, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.want {
+			t.Errorf("T=%d: code = %s, want %s", tt.time, code, tt.want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / int64(Period.Seconds())
+
+	tests := []struct {
+		name   string
+		offset int64 // in time steps from now
+		want   bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps back", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := GenerateCode(rfcSecret, now.Add(time.Duration(tt.offset)*Period))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			counter, ok := Validate(code, rfcSecret, now)
+			if ok != tt.want {
+				t.Fatalf("ok = %t, want %t", ok, tt.want)
+			}
+			if ok && counter != step+tt.offset {
+				t.Errorf("counter = %d, want %d", counter, step+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		code   string
+		secret string
+	}{
+		{"wrong code", "000000", rfcSecret},
+		{"short code", "28708", rfcSecret},
+		{"long code", "2870820", rfcSecret},
+		{"invalid secret", "287082", "not base32!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.code, tt.secret, now); ok {
+				t.Error("code accepted")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
 )
@@ -43,6 +44,14 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+func IsURL(value string) bool { // absolute http(s) URL with a host
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func Unique[T comparable](values []T) bool { // generic to check whether values in a slice are unique
 	uniqueValues := make(map[T]bool)
 
@@ -3,11 +3,19 @@ package youtube
 import (
 	"context"
 	"fmt"
+	"net/http"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/googleapi/transport"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
+var tracer = otel.Tracer("api.ukrop.pl/internal/youtube")
+
 type SearchResult struct {
 	Artist       string `json:"artist"`
 	Title        string `json:"title"`
@@ -22,7 +30,14 @@ type Client struct {
 
 func New(apiKey string) (*Client, error) {
 	ctx := context.Background()
-	service, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+
+	// a client of our own replaces the one option.WithAPIKey would set up, so it carries the key itself
+	httpClient := &http.Client{Transport: &transport.APIKey{
+		Key:       apiKey,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}}
+
+	service, err := youtube.NewService(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create youtube service: %w", err)
 	}
@@ -30,7 +45,20 @@ func New(apiKey string) (*Client, error) {
 	return &Client{service: service}, nil
 }
 
+// Ping checks the API key with the cheapest call available, listing video categories.
+func (y *Client) Ping(ctx context.Context) error {
+	_, err := y.service.VideoCategories.List([]string{"id"}).RegionCode("PL").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("youtube ping failed: %w", err)
+	}
+
+	return nil
+}
+
 func (y *Client) SearchMusic(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+	ctx, span := tracer.Start(ctx, "youtube.search", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
 	call := y.service.Search.List([]string{"id", "snippet"}).
 		Q(query).
 		Type("video").
@@ -39,6 +67,8 @@ func (y *Client) SearchMusic(ctx context.Context, query string, maxResults int)
 
 	response, err := call.Context(ctx).Do()
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("youtube search call failed: %w", err)
 	}
 
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS recommendations_feed_idx;
+
+DROP INDEX IF EXISTS follows_followed_id_idx;
+
+DROP TABLE IF EXISTS follows;
\ No newline at end of file
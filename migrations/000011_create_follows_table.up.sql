@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS follows
+(
+    follower_id bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    followed_id bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followed_id),
+    CONSTRAINT follows_self_check CHECK ( follower_id <> followed_id )
+);
+
+CREATE INDEX IF NOT EXISTS follows_followed_id_idx ON follows (followed_id);
+
+CREATE INDEX IF NOT EXISTS recommendations_feed_idx ON recommendations (user_id, created_at DESC, id DESC);
\ No newline at end of file
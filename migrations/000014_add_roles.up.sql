@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    code text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO permissions (code)
+SELECT v.code
+FROM (VALUES ('reservations:read'), ('reservations:write')) AS v(code)
+WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.code = v.code);
+
+INSERT INTO roles (code)
+VALUES ('member'),
+       ('curator'),
+       ('admin');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+         CROSS JOIN permissions p
+WHERE (r.code = 'member' AND p.code IN ('recommendations:read', 'comments:write'))
+   OR (r.code = 'curator' AND p.code IN ('recommendations:read', 'recommendations:write', 'comments:write',
+                                         'reservations:read', 'reservations:write'))
+   OR r.code = 'admin';
+
+INSERT INTO users_roles (user_id, role_id)
+SELECT u.id, r.id
+FROM users u
+         CROSS JOIN roles r
+WHERE r.code = 'member';
\ No newline at end of file
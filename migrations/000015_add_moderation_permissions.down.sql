@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('recommendations:moderate', 'comments:moderate', 'reservations:moderate');
\ No newline at end of file
@@ -0,0 +1,12 @@
+INSERT INTO permissions (code)
+VALUES ('recommendations:moderate'),
+       ('comments:moderate'),
+       ('reservations:moderate');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+         CROSS JOIN permissions p
+WHERE (r.code = 'curator' AND p.code IN ('recommendations:moderate', 'comments:moderate'))
+   OR (r.code = 'admin' AND p.code IN ('recommendations:moderate', 'comments:moderate', 'reservations:moderate'))
+ON CONFLICT DO NOTHING;
\ No newline at end of file
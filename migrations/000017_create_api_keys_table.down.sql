@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS api_keys_user_id_idx;
+
+DROP TABLE IF EXISTS api_keys;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id      bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name         text                        NOT NULL,
+    hash         bytea UNIQUE                NOT NULL,
+    permissions  text[],
+    expiry       timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_unactivated_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS activated_at;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated_at timestamp(0) with time zone;
+
+-- anyone updated since registering may have been activated and then deactivated, so keep them too
+UPDATE users SET activated_at = created_at WHERE activated OR version > 1;
+
+CREATE INDEX IF NOT EXISTS users_unactivated_idx ON users (created_at) WHERE activated_at IS NULL;
\ No newline at end of file
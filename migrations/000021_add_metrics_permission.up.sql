@@ -0,0 +1,10 @@
+INSERT INTO permissions (code)
+VALUES ('metrics:view');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+         CROSS JOIN permissions p
+WHERE r.code = 'admin'
+  AND p.code = 'metrics:view'
+ON CONFLICT DO NOTHING;
\ No newline at end of file